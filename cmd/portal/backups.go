@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/portal"
+)
+
+// runBackups lists, restores or drops the working tree backups portal takes
+// before throwing work away.
+func runBackups(action string, id string) error {
+	switch action {
+	case "list":
+		return listBackups()
+	case "restore":
+		backup, err := git.FindBackup(id)
+		if err != nil {
+			return err
+		}
+
+		// restoring overwrites files too
+		if err := portal.BackUpWorktree("before restoring " + backup.ID); err != nil {
+			return err
+		}
+
+		if err := git.RestoreBackup(backup); err != nil {
+			return err
+		}
+
+		fmt.Printf("Restored backup %s (%s)\n", backup.ID, backup.Reason)
+	case "drop":
+		backups, err := droppedBackups(id)
+		if err != nil {
+			return err
+		}
+
+		for _, backup := range backups {
+			if err := git.DropBackup(backup); err != nil {
+				return err
+			}
+			fmt.Printf("Dropped backup %s\n", backup.ID)
+		}
+	default:
+		return fmt.Errorf("unknown action %s, expected list, restore or drop", action)
+	}
+
+	return nil
+}
+
+func listBackups() error {
+	backups, err := git.Backups()
+	if err != nil {
+		return err
+	}
+
+	if len(backups) == 0 {
+		fmt.Println("No backups")
+		return nil
+	}
+
+	for _, backup := range backups {
+		fmt.Printf("%s  %s  %s\n", backup.ID, backup.Sha[:7], backup.Reason)
+	}
+
+	return nil
+}
+
+func droppedBackups(id string) ([]git.Backup, error) {
+	if id == "all" {
+		return git.Backups()
+	}
+
+	backup, err := git.FindBackup(id)
+
+	return []git.Backup{backup}, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ericTsiliacos/portal/internal/portal"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+// preflight gathers the checks made before a saga runs. Outside of a dry run
+// the first failing check exits, just like validate.
+type preflight struct {
+	dryRun bool
+	checks []check
+}
+
+type check struct {
+	name    string
+	passed  bool
+	message string
+}
+
+func (p *preflight) validate(name string, valid bool, message string) {
+	if !p.dryRun {
+		validate(valid, message)
+		return
+	}
+
+	p.checks = append(p.checks, check{name: name, passed: valid, message: message})
+}
+
+// require is for checks the rest of the preflight depends on, so even a dry
+// run stops at them.
+func (p *preflight) require(name string, valid bool, message string) {
+	p.validate(name, valid, message)
+
+	if !valid {
+		p.print()
+		exit(1)
+	}
+}
+
+func (p *preflight) passed() bool {
+	for _, check := range p.checks {
+		if !check.passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *preflight) print() {
+	fmt.Println("Preflight:")
+	for _, check := range p.checks {
+		if check.passed {
+			fmt.Printf("  ✓ %s\n", check.name)
+		} else {
+			fmt.Printf("  ✗ %s: %s\n", check.name, strings.TrimSpace(check.message))
+		}
+	}
+}
+
+func printPlan(p *preflight, portalBranch string, config portal.Meta, steps []saga.Step) {
+	fmt.Printf("Portal branch: %s\n\n", portalBranch)
+
+	p.print()
+
+	data, err := yaml.Marshal(&config)
+	if err == nil {
+		fmt.Println()
+		fmt.Println("Meta:")
+		printOutput(string(data))
+	}
+
+	fmt.Println()
+	fmt.Println("Steps:")
+	for i, step := range steps {
+		fmt.Printf("  %d. %s\n", i+1, step.Name)
+		for _, condition := range step.Pre {
+			fmt.Printf("       requires: %s\n", condition.Name)
+		}
+		for _, command := range step.Commands {
+			fmt.Printf("       run:      %s\n", command)
+		}
+		for _, condition := range step.Post {
+			fmt.Printf("       ensures:  %s\n", condition.Name)
+		}
+		for _, command := range step.UndoCommands {
+			fmt.Printf("       undo:     %s\n", command)
+		}
+	}
+
+	if !p.passed() {
+		exit(1)
+	}
+}
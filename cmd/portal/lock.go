@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/lock"
+	"github.com/ericTsiliacos/portal/internal/logger"
+)
+
+var repositoryLock struct {
+	sync.Mutex
+	held *lock.Lock
+}
+
+// lockRepository keeps any other portal from changing the repository, from
+// whichever of its worktrees, until unlockRepository or exit.
+func lockRepository(worktree git.Worktree, command string) {
+	held, err := lock.Acquire(filepath.Join(worktree.CommonDir, "portal.lock"), command)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	repositoryLock.Lock()
+	repositoryLock.held = held
+	repositoryLock.Unlock()
+}
+
+func unlockRepository() {
+	repositoryLock.Lock()
+	defer repositoryLock.Unlock()
+
+	if repositoryLock.held == nil {
+		return
+	}
+
+	if err := repositoryLock.held.Release(); err != nil {
+		logger.LogError.Println(err)
+	}
+	repositoryLock.held = nil
+}
+
+// exit is how portal exits once it may hold the repository lock, releasing
+// it first.
+func exit(code int) {
+	unlockRepository()
+	os.Exit(code)
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -38,6 +41,13 @@ func main() {
 		SetDescription("Push changes to a portal branch").
 		AddFlag("verbose,v", "verbose output", commando.Bool, false).
 		AddFlag("strategy,s", "git-duet, git-together", commando.String, "auto").
+		AddFlag("include-ignored", "comma separated globs of ignored files to carry", commando.String, unset).
+		AddFlag("stash", "carry the stash list", commando.Bool, false).
+		AddFlag("keep", "keep your working tree, index and commits as they are after sending", commando.Bool, false).
+		AddFlag("hooks", "run, bypass, report: what to do with commit hooks on the portal commit", commando.String, "run").
+		AddFlag("dry-run", "print the preflight checks and git commands without running them", commando.Bool, false).
+		AddFlag("timeout", "roll back if not done within this long, e.g. 90s or 5m (0 for no limit)", commando.String, "0").
+		AddFlag("backend", "git, go-git: what answers local queries about the repository, origin is always asked by git", commando.String, "git").
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
 
 			logger.LogInfo.Println(fmt.Sprintf("Portal: %s", version))
@@ -46,39 +56,136 @@ func main() {
 
 			verbose, _ := flags["verbose"].GetBool()
 			strategy, _ := flags["strategy"].GetString()
+			includeIgnored := optionalString(flags, "include-ignored")
+			stash, _ := flags["stash"].GetBool()
+			keep, _ := flags["keep"].GetBool()
+			hookPolicy, _ := flags["hooks"].GetString()
+			dryRun, _ := flags["dry-run"].GetBool()
+			timeout, err := timeoutFlag(flags)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
 
-			validate(git.IsGitProject(), constants.GitProject)
+			backend, _ := flags["backend"].GetString()
+			if err := git.UseBackend(backend); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			checks := &preflight{dryRun: dryRun}
+
+			checks.require("inside a git project", git.IsGitProject(), constants.GitProject)
+
+			worktree, err := enterWorktree()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			if !dryRun {
+				lockRepository(worktree, "push")
+				defer unlockRepository()
+			}
 
 			portalBranch, err := portal.BranchNameStrategy(strategy)
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			validate(git.DirtyIndex() || git.UnpublishedWork(), constants.EmptyIndex)
-			validate(git.CurrentBranchRemotelyTracked(), constants.RemoteTrackingRequired)
-			validate(!git.LocalBranchExists(portalBranch), constants.LocalBranchExists(portalBranch))
-			validate(!git.RemoteBranchExists(portalBranch), constants.RemoteBranchExists(portalBranch))
+			ignoredFiles, err := git.IgnoredFiles(portal.IncludeIgnoredPatterns(includeIgnored))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
 
-			ctx, cancel, signalChan := cancelContext()
-			defer stop(cancel, signalChan)
-			go handleCancel(ctx, cancel, signalChan)
+			stashes := []portal.Stash{}
+			if stash {
+				stashes, err = portal.StashEntries()
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					exit(1)
+				}
+
+				stashBranch := portal.StashBranch(portalBranch)
+				checks.validate("remote stash branch is free", !checked(git.RemoteBranchExists(stashBranch)), constants.RemoteBranchExists(stashBranch))
+			}
+
+			checks.validate("there is work to push", checked(git.DirtyIndex()) || checked(git.UnpublishedWork()) || len(ignoredFiles) > 0 || len(stashes) > 0, constants.EmptyIndex)
 
-			pushSteps, err := portal.PushSagaSteps(ctx, portalBranch, version, verbose, commitMessage)
+			// a dry run writes no objects, so it shows the submodules without
+			// their patches
+			snapshot := portal.SnapshotSubmodules
+			if dryRun {
+				snapshot = portal.DirtySubmodules
+			}
+			submodules, err := snapshot()
+			checks.validate("dirty submodules can be carried", err == nil, fmt.Sprint(err))
+
+			config := portal.Meta{}
+			config.Meta.Version = version
+			config.Meta.Message = commitMessage
+			config.Meta.IgnoredFiles = ignoredFiles
+			config.Meta.Submodules = submodules
+			config.Meta.Stashes = stashes
+			config.Meta.Kept = keep
+
+			checks.require("current branch is remotely tracked", git.CurrentBranchRemotelyTracked(), constants.RemoteTrackingRequired)
+			checks.validate("local portal branch is free", !checked(git.LocalBranchExists(portalBranch)), constants.LocalBranchExists(portalBranch))
+			checks.validate("remote portal branch is free", !checked(git.RemoteBranchExists(portalBranch)), constants.RemoteBranchExists(portalBranch))
+
+			workingBranch, err := git.GetCurrentBranch()
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			errors := stylized(verbose, func() []string {
-				saga := saga.New(pushSteps)
-				return saga.Run()
-			})
+			policy, err := portal.ParseHookPolicy(hookPolicy)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			hookFailures := []error{}
+			hooks := portal.Hooks{Policy: policy, Reported: func(err error) {
+				hookFailures = append(hookFailures, err)
+			}}
 
-			if errors != nil {
-				for _, error := range errors {
-					fmt.Println(error)
+			pushSteps, err := portal.PushSagaSteps(portalBranch, config, hooks, verbose)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			if dryRun {
+				meta, err := portal.PushMeta(config)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					exit(1)
 				}
+
+				printPlan(checks, portalBranch, meta, pushSteps)
+				return
+			}
+
+			residual := func() (portal.Residual, error) {
+				return portal.PushResidual(workingBranch, portalBranch)
+			}
+
+			ctx, cancel, signalChan := cancelContext(timeout)
+			defer stop(cancel, signalChan)
+			go handleCancel(cancel, signalChan, residual)
+
+			pushSaga := saga.New(pushSteps)
+			err = traced("push", workingBranch, portalBranch, &pushSaga, func() error {
+				return stylized(ctx, verbose, &pushSaga, pushSaga.Run)
+			})
+
+			printHookFailures(hookFailures)
+
+			if err != nil {
+				report(err, residual)
 			} else {
 				fmt.Println("✨ Sent!")
 			}
@@ -89,95 +196,421 @@ func main() {
 		SetDescription("Pull changes from portal branch").
 		AddFlag("verbose,v", "verbose output", commando.Bool, false).
 		AddFlag("strategy,s", "git-duet, git-together", commando.String, "auto").
+		AddFlag("rebase", "apply the work on top of where the working branch is now, pausing on conflicts", commando.Bool, false).
+		AddFlag("onto", "a branch to apply the work on instead of the pusher's, created if missing", commando.String, unset).
+		AddFlag("autostash", "stash your own changes for the pull and apply them again after", commando.Bool, false).
+		AddFlag("continue", "finish a pull paused on conflicts once they are resolved", commando.Bool, false).
+		AddFlag("abort", "give up a pull paused on conflicts, going back to before it", commando.Bool, false).
+		AddFlag("check", "predict whether the portal applies cleanly onto origin's working branch, changing nothing", commando.Bool, false).
+		AddFlag("dry-run", "print the preflight checks and git commands without running them", commando.Bool, false).
+		AddFlag("timeout", "roll back if not done within this long, e.g. 90s or 5m (0 for no limit)", commando.String, "0").
+		AddFlag("backend", "git, go-git: what answers local queries about the repository, origin is always asked by git", commando.String, "git").
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
 
 			logger.LogInfo.Println(fmt.Sprintf("Portal: %s", version))
 
 			verbose, _ := flags["verbose"].GetBool()
 			strategy, _ := flags["strategy"].GetString()
+			rebase, _ := flags["rebase"].GetBool()
+			autostash, _ := flags["autostash"].GetBool()
+			onto := optionalString(flags, "onto")
+			continuing, _ := flags["continue"].GetBool()
+			aborting, _ := flags["abort"].GetBool()
+			checking, _ := flags["check"].GetBool()
+			dryRun, _ := flags["dry-run"].GetBool()
+			timeout, err := timeoutFlag(flags)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
 
-			validate(git.IsGitProject(), constants.GitProject)
+			backend, _ := flags["backend"].GetString()
+			if err := git.UseBackend(backend); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			checks := &preflight{dryRun: dryRun}
+
+			checks.require("inside a git project", git.IsGitProject(), constants.GitProject)
+
+			worktree, err := enterWorktree()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			if !dryRun && !checking {
+				lockRepository(worktree, "pull")
+				defer unlockRepository()
+			}
+
+			if continuing || aborting {
+				resumePull(aborting, verbose, timeout)
+				return
+			}
+
+			paused, err := loadPausedPull()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			checks.require("no pull is paused", paused == nil, constants.PullPaused)
 
 			portalBranch, err := portal.BranchNameStrategy(strategy)
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			validate(git.RemoteBranchExists(portalBranch), constants.PortalClosed)
+			checks.require("portal is open", checked(git.RemoteBranchExists(portalBranch)), constants.PortalClosed)
 
-			_, _ = git.Fetch()
+			// a dry run or a check only looks, so it doesn't move any ref
+			portalWork := "origin/" + portalBranch
+			if dryRun || checking {
+				portalWork = fetchDetached(portalBranch)[portalBranch]
+			} else {
+				fetch()
+			}
 
-			metaFileContents, _ := git.ShowCommitMessage(portalBranch)
+			metaFileContents, _ := git.CommitMessage(portalWork)
 			config, _ := portal.GetConfiguration(metaFileContents)
 			workingBranch := config.Meta.WorkingBranch
 			pusherVersion := semver.Canonical(config.Meta.Version)
-			sha := config.Meta.Sha
 			pullerVersion := semver.Canonical(version)
 
-			validate(semver.Major(pusherVersion) == semver.Major(pullerVersion), constants.DifferentVersions)
-			validate(git.CurrentBranchRemotelyTracked(), constants.RemoteTrackingRequired)
+			checks.validate("same major version as the pusher", semver.Major(pusherVersion) == semver.Major(pullerVersion), constants.DifferentVersions)
+
+			if checking {
+				checks.require("git 2.38 or later", checked(git.MergeTreesSupported()), constants.CheckNeedsGit)
+				checkPull(*config, portalWork, onto)
+				return
+			}
+
+			if onto == "" {
+				checks.require("current branch is remotely tracked", git.CurrentBranchRemotelyTracked(), constants.RemoteTrackingRequired)
+			}
+
 			startingBranch, err := git.GetCurrentBranch()
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 
-			validate(workingBranch == startingBranch, constants.BranchMismatch(startingBranch, workingBranch))
-			validate(!git.DirtyIndex() && !git.UnpublishedWork(), constants.DirtyIndex(startingBranch))
+			// pulling onto another branch leaves the current one as it is,
+			// unpublished commits included
+			switch {
+			case onto != "":
+				checks.require("on a branch", startingBranch != "HEAD", constants.BranchRequired)
+				if !autostash {
+					checks.validate("no local changes", !checked(git.DirtyIndex()), constants.DirtyIndex(startingBranch))
+				}
+			case autostash:
+				checks.validate("on the pusher's working branch", workingBranch == startingBranch, constants.BranchMismatch(startingBranch, workingBranch))
+				checks.validate("no unpublished commits", !checked(git.UnpublishedWork()), constants.UnpublishedWork(startingBranch))
+			default:
+				checks.validate("on the pusher's working branch", workingBranch == startingBranch, constants.BranchMismatch(startingBranch, workingBranch))
+				checks.validate("nothing to lose locally", !checked(git.DirtyIndex()) && !checked(git.UnpublishedWork()), constants.DirtyIndex(startingBranch))
+			}
 
-			ctx, cancel, signalChan := cancelContext()
-			defer stop(cancel, signalChan)
-			go handleCancel(ctx, cancel, signalChan)
+			// git checks carried ignored files out over the puller's own
+			overwritten, err := git.OverwrittenFiles(portalWork, config.Meta.IgnoredFiles)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+			checks.validate("no ignored files overwritten", len(overwritten) == 0, constants.IgnoredFilesInTheWay(overwritten))
 
-			pullSteps, err := portal.PullSagaSteps(ctx, startingBranch, portalBranch, sha, verbose)
+			startingSha, err := git.HeadSha()
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
+				exit(1)
+			}
+
+			pull := portal.PausedPull{
+				StartingBranch: startingBranch,
+				StartingSha:    startingSha,
+				PortalBranch:   portalBranch,
+				Config:         *config,
+				Options:        portal.PullOptions{Rebase: rebase, Onto: onto, Autostash: autostash},
+			}
+
+			pullSteps, err := pull.Steps(verbose)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			if dryRun {
+				printPlan(checks, portalBranch, *config, pullSteps)
+				return
+			}
+
+			residual := func() (portal.Residual, error) {
+				return portal.PullResidual(startingBranch, portalBranch, startingSha)
 			}
 
-			errors := stylized(verbose, func() []string {
-				saga := saga.New(pullSteps)
-				return saga.Run()
+			ctx, cancel, signalChan := cancelContext(timeout)
+			defer stop(cancel, signalChan)
+			go handleCancel(cancel, signalChan, residual)
+
+			pullSaga := saga.New(pullSteps)
+			err = traced("pull", startingBranch, portalBranch, &pullSaga, func() error {
+				return stylized(ctx, verbose, &pullSaga, pullSaga.Run)
 			})
 
-			if errors != nil {
-				for _, error := range errors {
-					fmt.Println(error)
-				}
-			} else {
-				fmt.Println("✨ Got it!")
+			finishPull(pull, &pullSaga, err, residual)
+		})
+
+	commando.
+		Register("trace").
+		SetDescription("Show the trace of a push or pull").
+		AddArgument("id", "last, or the id of an earlier trace", "last").
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			validate(git.IsGitProject(), constants.GitProject)
+
+			if err := printTrace(args["id"].Value); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
 			}
 		})
 
-	commando.Parse(nil)
+	commando.
+		Register("backups").
+		SetDescription("List, restore or drop the backups taken of your working tree before portal throws work away").
+		AddArgument("action", "list, restore or drop", "list").
+		AddArgument("id", "last, the id of a backup, or all to drop every backup", "last").
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			validate(git.IsGitProject(), constants.GitProject)
+
+			worktree, err := enterWorktree()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+
+			action := args["action"].Value
+			if action != "list" {
+				lockRepository(worktree, "backups "+action)
+				defer unlockRepository()
+			}
+
+			if err := runBackups(action, args["id"].Value); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exit(1)
+			}
+		})
+
+	args, err := changeDirectory(os.Args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	commando.Parse(args[1:])
 }
 
-func stylized(verbose bool, fn func() []string) []string {
+// stylized shows the progress of s while run runs it.
+func stylized(ctx context.Context, verbose bool, s *saga.Saga, run func(ctx context.Context) error) error {
+	progress := &progress{}
+	s.Observe(progress.observe)
+
 	if verbose {
-		return fn()
+		err := run(ctx)
+
+		fmt.Println()
+		progress.printSummary()
+
+		return err
 	} else {
-		s := spinner.New(spinner.CharSets[23], 100*time.Millisecond)
-		s.Suffix = " Coming your way..."
-		s.Start()
+		spin := spinner.New(spinner.CharSets[23], 100*time.Millisecond)
+		spin.Suffix = " Coming your way..."
+		spin.PreUpdate = func(spin *spinner.Spinner) {
+			spin.Suffix = " Coming your way... " + progress.current()
+		}
+		spin.Start()
 
-		err := fn()
+		err := run(ctx)
 
-		s.Stop()
+		spin.Stop()
 
 		return err
 	}
 }
 
+// verifyPull checks the pull left the pusher's working tree, other than in
+// paths that hold the puller's own changes.
+func verifyPull(pullSaga *saga.Saga, config portal.Meta, own []string, residual func() (portal.Residual, error)) bool {
+	verification, err := portal.Verify(config)
+	if err != nil {
+		fmt.Printf("Warning: could not verify pull: %v\n", err)
+		return true
+	}
+	verification = verification.Without(own)
+
+	if verification.Matches() {
+		return true
+	}
+
+	fmt.Println(constants.TreeMismatch)
+	printDifferences(verification.Tree)
+
+	if !confirm(constants.RollbackPrompt) {
+		return true
+	}
+
+	if err = pullSaga.Undo(context.Background()); err != nil {
+		report(err, residual)
+	} else {
+		fmt.Println(constants.RolledBack)
+	}
+
+	return false
+}
+
+func report(err error, residual func() (portal.Residual, error)) {
+	var undoErrors saga.UndoErrors
+	var sagaError *saga.Error
+	var timeoutError *saga.TimeoutError
+
+	switch {
+	case errors.As(err, &sagaError) && sagaError.Cancelled():
+		fmt.Println(constants.Cancelled(sagaError.Step))
+
+		if sagaError.RolledBack() {
+			fmt.Println(constants.RolledBackSteps(sagaError.Undone))
+			exit(exitCodeInterrupt)
+		}
+
+		undoErrors = sagaError.UndoErrs
+	case errors.As(err, &sagaError):
+		if errors.As(sagaError.Err, &timeoutError) {
+			fmt.Printf("Error: %v\n", timeoutError)
+		} else {
+			fmt.Printf("Error: %s failed: %v\n", sagaError.Step, sagaError.Err)
+		}
+		printOutput(sagaError.Output)
+
+		if sagaError.RolledBack() {
+			fmt.Println(constants.RolledBackSteps(sagaError.Undone))
+			return
+		}
+
+		undoErrors = sagaError.UndoErrs
+	case errors.As(err, &undoErrors):
+	default:
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	for _, undoError := range undoErrors {
+		fmt.Printf("Error: undo %s failed: %v\n", undoError.Step, undoError.Err)
+		printOutput(undoError.Output)
+	}
+
+	fmt.Println(constants.InconsistentRepository(logger.LogFilePath))
+	printResidual(residual)
+}
+
+func printResidual(residual func() (portal.Residual, error)) {
+	state, err := residual()
+	if err != nil {
+		fmt.Printf("Warning: could not inspect what was left behind: %v\n", err)
+		return
+	}
+
+	fmt.Println(constants.LeftBehind)
+	for _, line := range state.State() {
+		fmt.Printf("  - %s\n", line)
+	}
+
+	commands := state.RecoveryCommands()
+	if len(commands) > 0 {
+		fmt.Println(constants.RecoveryCommands)
+		for _, command := range commands {
+			fmt.Printf("  %s\n", command)
+		}
+	}
+}
+
+func printHookFailures(failures []error) {
+	for _, failure := range failures {
+		var hookError *git.HookError
+		if errors.As(failure, &hookError) {
+			fmt.Printf("Warning: %v, committed anyway\n", hookError)
+			printOutput(hookError.Output)
+		}
+	}
+}
+
+func printOutput(output string) {
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
+
+func printDifferences(differences []string) {
+	for _, difference := range differences {
+		fmt.Printf("  %s\n", strings.Replace(difference, "\t", " ", 1))
+	}
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// unset is the default of optional string flags, as commando makes a string
+// flag with an empty default required. No branch name or trimmed glob can be
+// a tab.
+const unset = "\t"
+
+// optionalString is the value of an optional string flag, empty when it
+// wasn't given.
+func optionalString(flags map[string]commando.FlagValue, name string) string {
+	value, _ := flags[name].GetString()
+	if value == unset {
+		return ""
+	}
+
+	return value
+}
+
 func validate(valid bool, message string) {
 	if !valid {
 		fmt.Println(message)
-		os.Exit(1)
+		exit(1)
 	}
 }
 
-func cancelContext() (context.Context, context.CancelFunc, chan os.Signal) {
-	ctx, cancel := context.WithCancel(context.Background())
+// checked exits when git couldn't answer a check at all.
+func checked(value bool, err error) bool {
+	if err != nil {
+		fmt.Println()
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+
+	return value
+}
+
+// cancelContext is cancelled by an interrupt or, when timeout isn't zero, once
+// it has passed.
+func cancelContext(timeout time.Duration) (context.Context, context.CancelFunc, chan os.Signal) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = saga.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
 
@@ -189,12 +622,27 @@ func stop(cancel context.CancelFunc, signalChan chan os.Signal) {
 	cancel()
 }
 
-func handleCancel(ctx context.Context, cancel context.CancelFunc, signalChan chan os.Signal) {
-	select {
-	case <-signalChan:
-		cancel()
-	case <-ctx.Done():
+func timeoutFlag(flags map[string]commando.FlagValue) (time.Duration, error) {
+	value, _ := flags["timeout"].GetString()
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --timeout %s, expected a duration such as 90s or 5m", value)
 	}
+
+	return timeout, nil
+}
+
+// handleCancel rolls back on the first interrupt. A second one quits without
+// waiting for the rollback to finish. A timeout rolling back doesn't count as
+// the first one.
+func handleCancel(cancel context.CancelFunc, signalChan chan os.Signal, residual func() (portal.Residual, error)) {
+	<-signalChan
+	fmt.Println(constants.Cancelling)
+	cancel()
+
 	<-signalChan
-	os.Exit(exitCodeInterrupt)
+	fmt.Println(constants.ForceQuit(logger.LogFilePath))
+	printResidual(residual)
+	exit(exitCodeInterrupt)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+// progress follows a saga to show the step it is on while it runs, and how
+// long every step took once it's done.
+type progress struct {
+	mu      sync.Mutex
+	step    string
+	undoing bool
+	started time.Time
+	rows    []progressRow
+}
+
+type progressRow struct {
+	step    string
+	result  string
+	elapsed time.Duration
+}
+
+func (p *progress) observe(event saga.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Kind {
+	case saga.StepStarted, saga.UndoStarted:
+		p.step = event.Step
+		p.undoing = event.Kind == saga.UndoStarted
+		p.started = time.Now()
+	case saga.StepSucceeded:
+		p.rows = append(p.rows, progressRow{step: event.Step, result: "ok", elapsed: event.Elapsed})
+	case saga.StepFailed:
+		p.rows = append(p.rows, progressRow{step: event.Step, result: "failed", elapsed: event.Elapsed})
+	case saga.StepPaused:
+		p.rows = append(p.rows, progressRow{step: event.Step, result: "paused", elapsed: event.Elapsed})
+	case saga.UndoFinished:
+		result := "undone"
+		if event.Err != nil {
+			result = "undo failed"
+		}
+		p.rows = append(p.rows, progressRow{step: event.Step, result: result, elapsed: event.Elapsed})
+	}
+}
+
+func (p *progress) current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.step == "" {
+		return ""
+	}
+
+	step := p.step
+	if p.undoing {
+		step = "undo " + step
+	}
+
+	return fmt.Sprintf("%s (%s)", step, time.Since(p.started).Round(100*time.Millisecond))
+}
+
+func (p *progress) printSummary() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "STEP\tRESULT\tTIME")
+	for _, row := range p.rows {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", row.step, row.result, row.elapsed.Round(time.Millisecond))
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/constants"
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/logger"
+	"github.com/ericTsiliacos/portal/internal/portal"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+func loadPausedPull() (*portal.PausedPull, error) {
+	dir, err := git.StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return portal.LoadPausedPull(dir)
+}
+
+// resumePull continues the paused pull, or aborts it, from where it stopped.
+func resumePull(abort bool, verbose bool, timeout time.Duration) {
+	paused, err := loadPausedPull()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	validate(paused != nil, constants.NoPausedPull)
+
+	pullSteps, err := paused.Steps(verbose)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	pullSaga := saga.New(pullSteps)
+	if err := pullSaga.Restore(paused.Checkpoint); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	residual := func() (portal.Residual, error) {
+		return portal.PullResidual(paused.StartingBranch, paused.PortalBranch, paused.StartingSha)
+	}
+
+	ctx, cancel, signalChan := cancelContext(timeout)
+	defer stop(cancel, signalChan)
+	go handleCancel(cancel, signalChan, residual)
+
+	if abort {
+		err = traced("pull-abort", paused.StartingBranch, paused.PortalBranch, &pullSaga, func() error {
+			return pullSaga.Abort(ctx)
+		})
+		removePausedPull()
+
+		if err != nil {
+			report(err, residual)
+		} else {
+			fmt.Println(constants.PullAborted)
+		}
+
+		return
+	}
+
+	err = traced("pull-continue", paused.StartingBranch, paused.PortalBranch, &pullSaga, func() error {
+		return stylized(ctx, verbose, &pullSaga, pullSaga.Resume)
+	})
+
+	finishPull(*paused, &pullSaga, err, residual)
+}
+
+// finishPull reports how a run of the pull saga went. A pull that paused is
+// saved to be continued or aborted later.
+func finishPull(pull portal.PausedPull, pullSaga *saga.Saga, err error, residual func() (portal.Residual, error)) {
+	var paused *saga.PausedError
+	if errors.As(err, &paused) {
+		printConflicts(paused)
+
+		pull.Checkpoint = pullSaga.Checkpoint()
+		if err := savePausedPull(pull); err != nil {
+			fmt.Printf("Error: could not save the paused pull: %v\n", err)
+			printResidual(residual)
+		} else {
+			fmt.Println(constants.ResolveConflicts)
+		}
+
+		exit(1)
+	}
+
+	removePausedPull()
+
+	if err != nil {
+		report(err, residual)
+		return
+	}
+
+	autostash, err := portal.AutostashOf(pullSaga.State())
+	if err != nil {
+		fmt.Printf("Warning: could not inspect your stashed changes: %v\n", err)
+	}
+
+	var stashed []string
+	if autostash != nil {
+		stashed = autostash.Files
+	}
+
+	// a rebased pull differs from the pusher's tree by design
+	if !pull.Options.Rebased() && !verifyPull(pullSaga, pull.Config, stashed, residual) {
+		return
+	}
+
+	if autostash != nil && len(autostash.Conflicts) > 0 {
+		fmt.Println(constants.AutostashConflicts)
+		for _, file := range autostash.Conflicts {
+			fmt.Printf("  %s\n", file)
+		}
+		fmt.Println(constants.AutostashKept)
+	}
+
+	if pull.Config.Meta.Kept {
+		fmt.Println(constants.PusherKept)
+	}
+
+	fmt.Println("✨ Got it!")
+}
+
+// checkPull predicts whether the portal applies onto origin's working branch,
+// or onto the onto branch, exiting 1 when it would conflict.
+func checkPull(config portal.Meta, portalWork string, onto string) {
+	upstream, ok := fetchDetached(config.Meta.WorkingBranch)[config.Meta.WorkingBranch]
+	if !ok {
+		fmt.Printf("Error: origin has no branch %s\n", config.Meta.WorkingBranch)
+		exit(1)
+	}
+
+	check, err := portal.CheckPull(config, portalWork, upstream, onto)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	fmt.Println(constants.Checked(len(check.Files), check.Target, check.Behind))
+
+	if check.Clean() {
+		fmt.Println(constants.CheckClean)
+		return
+	}
+
+	fmt.Println(constants.CheckConflicts)
+	for _, file := range check.Conflicts {
+		fmt.Printf("  %s\n", file)
+	}
+	for _, message := range check.Messages {
+		if strings.HasPrefix(message, "CONFLICT") {
+			fmt.Println(message)
+		}
+	}
+
+	exit(1)
+}
+
+func fetch() {
+	err := portal.NetworkRetry.Do(context.Background(), "git fetch", func(context.Context) error {
+		return git.Fetch()
+	})
+	if err != nil {
+		fmt.Printf("Error: could not fetch: %v\n", err)
+		exit(1)
+	}
+}
+
+// fetchDetached fetches branches without moving any ref, returning where
+// each is on origin.
+func fetchDetached(branches ...string) (heads map[string]string) {
+	err := portal.NetworkRetry.Do(context.Background(), "git fetch", func(context.Context) (err error) {
+		heads, err = git.FetchDetached(branches...)
+		return
+	})
+	if err != nil {
+		fmt.Printf("Error: could not fetch: %v\n", err)
+		exit(1)
+	}
+
+	return
+}
+
+func printConflicts(paused *saga.PausedError) {
+	fmt.Println(constants.Paused(paused.Step))
+
+	var conflicts *portal.ConflictError
+	if errors.As(paused.Err, &conflicts) {
+		for _, file := range conflicts.Files {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+}
+
+func savePausedPull(pull portal.PausedPull) error {
+	dir, err := git.StateDir()
+	if err != nil {
+		return err
+	}
+
+	return portal.SavePausedPull(dir, pull)
+}
+
+func removePausedPull() {
+	dir, err := git.StateDir()
+	if err == nil {
+		err = portal.RemovePausedPull(dir)
+	}
+
+	if err != nil {
+		logger.LogError.Println(err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/logger"
+	"github.com/ericTsiliacos/portal/internal/portal"
+	"github.com/ericTsiliacos/portal/internal/saga"
+	"github.com/ericTsiliacos/portal/internal/trace"
+)
+
+func traceDir() (string, error) {
+	dir, err := git.StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "traces"), nil
+}
+
+// traced runs the saga through run, writing a trace of it. Failing to trace
+// doesn't stop the run.
+func traced(command string, workingBranch string, portalBranch string, s *saga.Saga, run func() error) error {
+	dir, err := traceDir()
+	if err != nil {
+		logger.LogError.Println(err)
+		return run()
+	}
+
+	t, err := trace.Start(dir, command, version)
+	if err != nil {
+		logger.LogError.Println(err)
+		return run()
+	}
+
+	s.Observe(t.Observe)
+
+	refs := tracedRefs(workingBranch, portalBranch)
+	before, _ := git.Refs(refs...)
+	t.Refs("before", before)
+
+	err = run()
+
+	after, _ := git.Refs(refs...)
+	t.Refs("after", after)
+	t.Finish(err, s.State().Values())
+
+	return err
+}
+
+func tracedRefs(workingBranch string, portalBranch string) []string {
+	return []string{
+		"refs/heads/" + workingBranch,
+		"refs/remotes/origin/" + workingBranch,
+		"refs/heads/" + portalBranch,
+		"refs/remotes/origin/" + portalBranch,
+		"refs/remotes/origin/" + portal.StashBranch(portalBranch),
+		"refs/stash",
+	}
+}
+
+func printTrace(id string) error {
+	dir, err := traceDir()
+	if err != nil {
+		return err
+	}
+
+	id, entries, err := trace.Read(dir, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Trace %s\n", id)
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case trace.TypeStart:
+			fmt.Printf("portal %s %s\n", strings.TrimSpace(entry.Run+" "+entry.Version), entry.Time.Format(time.RFC1123))
+		case trace.TypeRefs:
+			fmt.Printf("\nRefs %s:\n", entry.When)
+			printMap(entry.Refs)
+			fmt.Println()
+		case trace.TypeStep:
+			printStepEntry(entry)
+		case trace.TypeCommand:
+			fmt.Printf("      $ %s  exit %d, %s\n", commandLine(entry.Command), entry.ExitCode, milliseconds(entry.Duration))
+			if entry.ExitCode != 0 {
+				printIndented(entry.Stderr, "          ")
+			}
+		case trace.TypeEnd:
+			if entry.Paused {
+				fmt.Printf("\nResult: paused, %s\n", entry.Error)
+			} else if entry.Error != "" {
+				fmt.Printf("\nResult: failed, %s\n", entry.Error)
+			} else {
+				fmt.Println("\nResult: done")
+			}
+			if len(entry.State) > 0 {
+				fmt.Println("Recorded:")
+				printMap(entry.State)
+			}
+			if moves := portal.Moves(entry.State); len(moves) > 0 {
+				fmt.Println("Moved:")
+				for _, move := range moves {
+					fmt.Printf("  %s\n", move)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func printStepEntry(entry trace.Entry) {
+	switch entry.Event {
+	case saga.StepStarted.String():
+		fmt.Printf("  %s\n", entry.Step)
+	case saga.UndoStarted.String():
+		fmt.Printf("  undo %s\n", entry.Step)
+	case saga.StepFailed.String():
+		fmt.Printf("    ✗ failed after %s: %s\n", milliseconds(entry.Duration), entry.Error)
+	case saga.StepPaused.String():
+		fmt.Printf("    ‖ paused after %s: %s\n", milliseconds(entry.Duration), entry.Error)
+	case saga.UndoFinished.String():
+		if entry.Error != "" {
+			fmt.Printf("    ✗ undo failed after %s: %s\n", milliseconds(entry.Duration), entry.Error)
+		} else {
+			fmt.Printf("    ✓ undone in %s\n", milliseconds(entry.Duration))
+		}
+	default:
+		fmt.Printf("    ✓ done in %s\n", milliseconds(entry.Duration))
+	}
+}
+
+func printMap(values map[string]string) {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Printf("  %s %s\n", values[key], key)
+	}
+}
+
+func printIndented(output string, indent string) {
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			fmt.Printf("%s%s\n", indent, line)
+		}
+	}
+}
+
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if strings.ContainsAny(arg, " \n'\"") {
+			quoted[i] = fmt.Sprintf("%q", arg)
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func milliseconds(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/logger"
+)
+
+// changeDirectory handles -C <path> ahead of the command, as in
+// `portal -C ../other push`. Like git, several are resolved one after the
+// other.
+func changeDirectory(args []string) ([]string, error) {
+	for len(args) > 1 && args[1] == "-C" {
+		if len(args) < 3 {
+			return nil, errors.New("-C requires a path")
+		}
+
+		if err := os.Chdir(args[2]); err != nil {
+			return nil, err
+		}
+
+		args = append(args[:1], args[3:]...)
+	}
+
+	return args, nil
+}
+
+// enterWorktree moves to the top level of the worktree portal was run in,
+// so nothing depends on the subdirectory it was run from, which a push may
+// well remove.
+func enterWorktree() (git.Worktree, error) {
+	worktree, err := git.CurrentWorktree()
+	if err != nil {
+		return worktree, err
+	}
+
+	logger.LogInfo.Printf("Worktree: %s (git dir %s)", worktree.TopLevel, worktree.GitDir)
+
+	return worktree, os.Chdir(worktree.TopLevel)
+}
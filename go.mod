@@ -5,10 +5,11 @@ go 1.15
 require (
 	github.com/briandowns/spinner v1.23.0
 	github.com/fatih/color v1.14.1 // indirect
-	github.com/stretchr/testify v1.6.1
+	github.com/go-git/go-git/v5 v5.4.2
+	github.com/stretchr/testify v1.7.0
 	github.com/thatisuday/commando v1.0.4
 	golang.org/x/mod v0.9.0
+	golang.org/x/sys v0.6.0
 	golang.org/x/term v0.6.0 // indirect
-	golang.org/x/xerrors v0.0.0-20191011141410-1b5146add898 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,16 +1,36 @@
 package constants
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 const EmptyIndex = "nothing to push!"
 const PortalClosed = "nothing to pull!"
 const RemoteTrackingRequired = "must be on a branch that is remotely tracked"
+const BranchRequired = "must be on a branch"
 const DifferentVersions = `
 Pusher and Puller are using different versions of portal
  1. Pusher run portal pull to retrieve changes.
  2. Both pairs update to latest version of portal.
 Then try again...`
 const GitProject = "not a git project"
+const TreeMismatch = "working tree does not match what was pushed:"
+const RollbackPrompt = "Roll back the pull? [y/N] "
+const RolledBack = "pull rolled back, the portal is still open"
+const LeftBehind = "Left behind:"
+const RecoveryCommands = "To recover, run:"
+const PullPaused = "a pull is paused, run portal pull --continue or portal pull --abort"
+const NoPausedPull = "no pull is paused"
+const ResolveConflicts = "Resolve them and stage them with git add, then run portal pull --continue, or run portal pull --abort to go back to where you were."
+const PullAborted = "pull aborted, your repository is as it was before and the portal is still open"
+const AutostashConflicts = "Your own changes conflict with the pulled work in:"
+const AutostashKept = "The conflicts are marked in those files, and your changes are kept in the stash as \"portal autostash\"."
+const PusherKept = "The pusher kept their own copy of this work, it is still in their working tree."
+const CheckConflicts = "Applying it there would stop on conflicts in:"
+const CheckClean = "The portal applies cleanly, nothing was changed."
+const CheckNeedsGit = "pull --check merges in memory, which needs git 2.38 or later."
+const Cancelling = "\nCancelling, rolling back... press Ctrl-C again to quit without rolling back"
 
 func LocalBranchExists(branch string) string {
 	return fmt.Sprintf("local branch %s already exists", branch)
@@ -24,6 +44,50 @@ func DirtyIndex(branch string) string {
 	return fmt.Sprintf("%s: git index dirty!", branch)
 }
 
+func UnpublishedWork(branch string) string {
+	return fmt.Sprintf("%s: has unpublished commits!", branch)
+}
+
 func BranchMismatch(startingBranch string, workingBranch string) string {
 	return fmt.Sprintf("Starting branch %s did not match target branch %s", startingBranch, workingBranch)
 }
+
+func IgnoredFilesInTheWay(files []string) string {
+	return fmt.Sprintf("the portal carries ignored files you have with other contents, move them aside first: %s", strings.Join(files, ", "))
+}
+
+func RolledBackSteps(steps []string) string {
+	if len(steps) == 0 {
+		return "nothing to roll back, your repository is unchanged"
+	}
+
+	return fmt.Sprintf("rolled back %s, your repository is as it was before", strings.Join(steps, ", "))
+}
+
+func Checked(files int, target string, behind int) string {
+	return fmt.Sprintf("Checked the portal's %s against %s, %s past the pusher's base.", plural(files, "file"), target, plural(behind, "commit"))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func Paused(step string) string {
+	return fmt.Sprintf("Paused: %s hit conflicts in:", step)
+}
+
+func InconsistentRepository(logFilePath string) string {
+	return fmt.Sprintf("Your repository could not be fully rolled back, every command portal ran is logged in %s", logFilePath)
+}
+
+func Cancelled(step string) string {
+	return fmt.Sprintf("Cancelled during %s", step)
+}
+
+func ForceQuit(logFilePath string) string {
+	return fmt.Sprintf("\nQuit before rolling back, your repository may be left halfway. Every command portal ran is logged in %s", logFilePath)
+}
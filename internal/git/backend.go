@@ -0,0 +1,121 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend answers the read-only queries portal makes about a repository.
+// Everything that changes the repository or its remote is always done by
+// the git binary, through the Runner.
+type Backend interface {
+	CurrentBranch() (string, error)
+	RemoteTrackingBranch() (string, error)
+	HeadSha() (string, error)
+
+	// BoundarySha is where currentBranch diverges from
+	// remoteTrackingBranch, or its head when it has nothing unpublished.
+	BoundarySha(remoteTrackingBranch string, currentBranch string) (string, error)
+
+	DirtyIndex() (bool, error)
+	UnpublishedWork() (bool, error)
+	LocalBranchExists(branch string) (bool, error)
+
+	// RemoteHeads maps each of branches that origin has to its sha.
+	RemoteHeads(branches ...string) (map[string]string, error)
+}
+
+const (
+	// ExecBackend asks the git binary and parses what it prints.
+	ExecBackend = "git"
+
+	// GoGitBackend reads the repository with go-git, without running git.
+	GoGitBackend = "go-git"
+)
+
+// Backends are the names UseBackend accepts.
+var Backends = []string{ExecBackend, GoGitBackend}
+
+var backend Backend = execBackend{}
+
+// UseBackend picks the backend that answers queries from now on.
+func UseBackend(name string) error {
+	switch name {
+	case ExecBackend:
+		backend = execBackend{}
+	case GoGitBackend:
+		backend = goGitBackend{}
+	default:
+		return fmt.Errorf("unknown backend %s, expected one of %s", name, strings.Join(Backends, ", "))
+	}
+
+	return nil
+}
+
+type execBackend struct{}
+
+func (execBackend) CurrentBranch() (string, error) {
+	return line("rev-parse", "--abbrev-ref", "HEAD")
+}
+
+func (execBackend) RemoteTrackingBranch() (string, error) {
+	return line("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+}
+
+func (execBackend) HeadSha() (string, error) {
+	return line("rev-parse", "HEAD")
+}
+
+func (execBackend) BoundarySha(remoteTrackingBranch string, currentBranch string) (string, error) {
+	revisionBoundaries, err := output("rev-list", "--boundary", fmt.Sprintf("%s..%s", remoteTrackingBranch, currentBranch))
+	if err != nil {
+		return "", err
+	}
+
+	if len(revisionBoundaries) > 0 {
+		return parseRefBoundary(revisionBoundaries), nil
+	}
+
+	return line("rev-parse", currentBranch)
+}
+
+func (execBackend) DirtyIndex() (bool, error) {
+	index, err := output("status", "--porcelain=v1")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Count(index, "\n") > 0, nil
+}
+
+func (execBackend) UnpublishedWork() (bool, error) {
+	status, err := output("status", "-sb")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(status, "ahead"), nil
+}
+
+func (execBackend) LocalBranchExists(branch string) (bool, error) {
+	localBranch, err := output("branch", "--list", branch)
+	if err != nil {
+		return false, err
+	}
+
+	return len(localBranch) > 0, nil
+}
+
+func (execBackend) RemoteHeads(branches ...string) (map[string]string, error) {
+	refs := []string{}
+	for _, branch := range branches {
+		refs = append(refs, "refs/heads/"+branch)
+	}
+
+	heads, err := output(append([]string{"ls-remote", "--heads", "origin"}, refs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRemoteHeads(heads, branches), nil
+}
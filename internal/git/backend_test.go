@@ -0,0 +1,119 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func repository(t *testing.T) {
+	root, err := ioutil.TempDir("", "backends")
+	assert.NoError(t, err)
+
+	directory, err := os.Getwd()
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = os.Chdir(directory)
+		_ = os.RemoveAll(root)
+	})
+
+	run := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	clone := filepath.Join(root, "clone")
+	run(root, "init", "--bare", "origin.git")
+	run(root, "clone", "origin.git", "clone")
+	run(clone, "commit", "--allow-empty", "-m", "published")
+	run(clone, "push", "origin", "HEAD")
+	run(clone, "commit", "--allow-empty", "-m", "unpublished")
+	run(clone, "branch", "pa-ir")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(clone, "new.txt"), []byte("new"), 0644))
+
+	assert.NoError(t, os.Chdir(clone))
+}
+
+func TestBackendsAgree(t *testing.T) {
+	repository(t)
+
+	answers := func(b Backend) []interface{} {
+		current, currentErr := b.CurrentBranch()
+		tracking, trackingErr := b.RemoteTrackingBranch()
+		head, headErr := b.HeadSha()
+		boundary, boundaryErr := b.BoundarySha(tracking, current)
+		dirty, dirtyErr := b.DirtyIndex()
+		unpublished, unpublishedErr := b.UnpublishedWork()
+		local, localErr := b.LocalBranchExists("pa-ir")
+		missing, missingErr := b.LocalBranchExists("pa")
+		heads, headsErr := b.RemoteHeads(current, "pa-ir")
+
+		for _, err := range []error{currentErr, trackingErr, headErr, boundaryErr, dirtyErr, unpublishedErr, localErr, missingErr, headsErr} {
+			assert.NoError(t, err)
+		}
+
+		return []interface{}{current, tracking, head, boundary, dirty, unpublished, local, missing, heads}
+	}
+
+	expected := answers(execBackend{})
+
+	assert.Equal(t, true, expected[4])
+	assert.Equal(t, true, expected[5])
+	assert.Equal(t, expected, answers(goGitBackend{}))
+}
+
+func TestUnknownBackend(t *testing.T) {
+	assert.Error(t, UseBackend("libgit2"))
+}
+
+func TestBackendsAgreeInLinkedWorktree(t *testing.T) {
+	repository(t)
+
+	linked, err := filepath.Abs(filepath.Join("..", "linked"))
+	assert.NoError(t, err)
+
+	for _, args := range [][]string{
+		{"worktree", "add", "-b", "linked", linked},
+		{"-C", linked, "push", "--set-upstream", "origin", "linked"},
+		{"-C", linked, "commit", "--allow-empty", "-m", "unpublished in linked"},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	assert.NoError(t, os.Chdir(linked))
+
+	for _, b := range []Backend{execBackend{}, goGitBackend{}} {
+		current, err := b.CurrentBranch()
+		assert.NoError(t, err)
+		assert.Equal(t, "linked", current)
+
+		tracking, err := b.RemoteTrackingBranch()
+		assert.NoError(t, err)
+		assert.Equal(t, "origin/linked", tracking)
+
+		unpublished, err := b.UnpublishedWork()
+		assert.NoError(t, err)
+		assert.True(t, unpublished)
+
+		dirty, err := b.DirtyIndex()
+		assert.NoError(t, err)
+		assert.False(t, dirty)
+	}
+
+	worktree, err := CurrentWorktree()
+	assert.NoError(t, err)
+	assert.True(t, worktree.Linked())
+	assert.Equal(t, filepath.Join(worktree.CommonDir, "worktrees", "linked"), worktree.GitDir)
+
+	stateDir, err := StateDir()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(worktree.GitDir, "portal"), stateDir)
+}
@@ -0,0 +1,194 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupRefs = "refs/portal/backup/"
+
+// DefaultBackupRetention is how many backups are kept when
+// portal.backupRetention isn't configured.
+const DefaultBackupRetention = 20
+
+// Backup is a snapshot of the working tree, untracked files included, taken
+// as a commit on top of where HEAD was.
+type Backup struct {
+	ID     string
+	Sha    string
+	Reason string
+	refs   string
+}
+
+func (b Backup) Ref() string {
+	return b.refs + b.ID
+}
+
+// BackupRetention is how many backups are kept, from portal.backupRetention.
+// 0 turns backups off.
+func BackupRetention() (int, error) {
+	retention, err := line("config", "--get", "portal.backupRetention")
+	if err != nil || retention == "" {
+		// git config exits 1 when the key isn't set
+		return DefaultBackupRetention, nil
+	}
+
+	keep, err := strconv.Atoi(retention)
+	if err != nil || keep < 0 {
+		return 0, fmt.Errorf("portal.backupRetention must be a number of backups, not %q", retention)
+	}
+
+	return keep, nil
+}
+
+// CreateBackup snapshots the working tree of the repository in dir, the
+// current one when empty, under a new backup ref, ignored files matching
+// portal.includeIgnored included. The backup commit's parent is HEAD, so it
+// keeps whatever HEAD was as well.
+func CreateBackup(dir string, reason string) (Backup, error) {
+	worktree, err := worktreeIn(dir)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	ignored, err := ignoredFiles(dir, includeIgnoredPatterns(dir))
+	if err != nil {
+		return Backup{}, err
+	}
+
+	tree, err := worktreeTree(dir, ignored)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	sha, err := line(in(dir, "commit-tree", tree, "-p", "HEAD", "-m", reason)...)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	id := time.Now().Format("20060102T150405.000")
+	for n := 1; ; n++ {
+		if _, err := line(in(dir, "rev-parse", "--verify", "--quiet", worktree.backupRefs()+id)...); err != nil {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", strings.SplitN(id, "-", 2)[0], n)
+	}
+
+	backup := Backup{ID: id, Sha: sha, Reason: reason, refs: worktree.backupRefs()}
+	if _, err := output(in(dir, "update-ref", "-m", reason, backup.Ref(), sha, "")...); err != nil {
+		return Backup{}, err
+	}
+
+	return backup, nil
+}
+
+// Backups are the backups kept of the current worktree, oldest first.
+func Backups() ([]Backup, error) {
+	return backups("")
+}
+
+func backups(dir string) ([]Backup, error) {
+	worktree, err := worktreeIn(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := worktree.backupRefs()
+	refs, err := output(in(dir, "for-each-ref", "--sort=refname", "--format=%(refname) %(objectname) %(contents:subject)", namespace)...)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, ref := range splitLines(refs) {
+		fields := strings.SplitN(ref, " ", 3)
+		backup := Backup{ID: strings.TrimPrefix(fields[0], namespace), Sha: fields[1], refs: namespace}
+		if len(fields) > 2 {
+			backup.Reason = fields[2]
+		}
+		backups = append(backups, backup)
+	}
+
+	return backups, nil
+}
+
+// FindBackup is the backup with id, "last" being the latest one.
+func FindBackup(id string) (Backup, error) {
+	backups, err := Backups()
+	if err != nil {
+		return Backup{}, err
+	}
+
+	if id == "last" {
+		if len(backups) == 0 {
+			return Backup{}, errors.New("there are no backups")
+		}
+
+		return backups[len(backups)-1], nil
+	}
+
+	for _, backup := range backups {
+		if backup.ID == id {
+			return backup, nil
+		}
+	}
+
+	return Backup{}, fmt.Errorf("no backup %s", id)
+}
+
+// RestoreBackup writes the files of a backup over the working tree and
+// leaves them unstaged. Files the backup doesn't have are left alone.
+func RestoreBackup(backup Backup) error {
+	topLevel, err := TopLevel()
+	if err != nil {
+		return err
+	}
+
+	if _, err := output("-C", topLevel, "checkout", backup.Sha, "--", "."); err != nil {
+		return err
+	}
+
+	_, err = output("-C", topLevel, "reset", "--quiet")
+
+	return err
+}
+
+func DropBackup(backup Backup) error {
+	return dropBackup("", backup)
+}
+
+func dropBackup(dir string, backup Backup) error {
+	_, err := output(in(dir, "update-ref", "-d", backup.Ref(), backup.Sha)...)
+
+	return err
+}
+
+// PruneBackups drops the oldest backups of the repository in dir past the
+// keep latest.
+func PruneBackups(dir string, keep int) error {
+	backups, err := backups(dir)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		if err := dropBackup(dir, backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// in runs args in the repository in dir, the current one when empty.
+func in(dir string, args ...string) []string {
+	if dir == "" {
+		return args
+	}
+
+	return append([]string{"-C", dir}, args...)
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ericTsiliacos/portal/internal/shell"
+)
+
+// FakeRunner answers git commands from a script instead of running git, and
+// keeps every command it was asked to run.
+type FakeRunner struct {
+	mu        sync.Mutex
+	responses []*FakeResponse
+	commands  []Command
+}
+
+// FakeResponse is how a FakeRunner answers commands starting with some
+// args.
+type FakeResponse struct {
+	args   []string
+	result Result
+	err    error
+	answer func(args []string) string
+}
+
+// On scripts the answer to commands whose args start with args. When several
+// responses match, the one scripted last wins. It answers with empty output
+// until told otherwise.
+func (f *FakeRunner) On(args ...string) *FakeResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	response := &FakeResponse{args: args}
+	f.responses = append(f.responses, response)
+
+	return response
+}
+
+// Returns makes the command succeed, writing stdout.
+func (r *FakeResponse) Returns(stdout string) {
+	r.result = Result{Stdout: stdout}
+	r.err = nil
+	r.answer = nil
+}
+
+// Answers makes the command succeed, writing what answer makes of its args,
+// for a script that follows what the commands before it did.
+func (r *FakeResponse) Answers(answer func(args []string) string) {
+	r.result = Result{}
+	r.err = nil
+	r.answer = answer
+}
+
+// Fails makes the command exit with exitCode, writing stderr.
+func (r *FakeResponse) Fails(exitCode int, stderr string) {
+	r.result = Result{Stderr: stderr, ExitCode: exitCode}
+	r.err = fmt.Errorf("exit status %d", exitCode)
+	r.answer = nil
+}
+
+func (f *FakeRunner) Run(ctx context.Context, command Command) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands = append(f.commands, command)
+
+	if err := ctx.Err(); err != nil {
+		return Result{ExitCode: -1}, f.commandError(command, Result{}, err)
+	}
+
+	for i := len(f.responses) - 1; i >= 0; i-- {
+		response := f.responses[i]
+		if !startsWith(command.Args, response.args) {
+			continue
+		}
+
+		if response.err != nil {
+			return response.result, f.commandError(command, response.result, response.err)
+		}
+
+		if response.answer != nil {
+			return Result{Stdout: response.answer(command.Args)}, nil
+		}
+
+		return response.result, nil
+	}
+
+	return Result{ExitCode: -1}, f.commandError(command, Result{}, errors.New("not scripted"))
+}
+
+// Ran lists the commands run so far, as they would be typed.
+func (f *FakeRunner) Ran() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ran := []string{}
+	for _, command := range f.commands {
+		ran = append(ran, command.String())
+	}
+
+	return ran
+}
+
+func (f *FakeRunner) commandError(command Command, result Result, err error) error {
+	return &shell.CommandError{Command: command.String(), Output: result.Stdout + result.Stderr, Err: err}
+}
+
+func startsWith(args []string, prefix []string) bool {
+	if len(prefix) > len(args) {
+		return false
+	}
+
+	return strings.Join(args[:len(prefix)], "\x00") == strings.Join(prefix, "\x00")
+}
@@ -1,58 +1,52 @@
 package git
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/ericTsiliacos/portal/internal/char"
-	"github.com/ericTsiliacos/portal/internal/shell"
+	"github.com/ericTsiliacos/portal/internal/slices"
 )
 
 func CurrentBranchRemotelyTracked() bool {
-	remoteBranch, err := shell.Execute("git rev-parse --abbrev-ref --symbolic-full-name @{u}")
+	remoteBranch, err := backend.RemoteTrackingBranch()
 	return len(remoteBranch) > 0 && err == nil
 }
 
-func DirtyIndex() bool {
-	index := shell.Check(shell.Execute("git status --porcelain=v1"))
-
-	indexCount := strings.Count(index, "\n")
-	return indexCount > 0
+func DirtyIndex() (bool, error) {
+	return backend.DirtyIndex()
 }
 
 func IsGitProject() bool {
-	output, err := shell.Execute("git rev-parse --is-inside-work-tree")
+	isGitProject, err := line("rev-parse", "--is-inside-work-tree")
 
 	if err != nil {
 		return false
 	}
 
-	isGitProject := strings.TrimSuffix(output, "\n")
-
 	return isGitProject == "true"
 }
 
-func UnpublishedWork() bool {
-	output := shell.Check(shell.Execute("git status -sb"))
-
-	return strings.Contains(output, "ahead")
+func UnpublishedWork() (bool, error) {
+	return backend.UnpublishedWork()
 }
 
-func LocalBranchExists(branch string) bool {
-	localBranch := shell.Check(shell.Execute(fmt.Sprintf("git branch --list %s", branch)))
-
-	return len(localBranch) > 0
+func LocalBranchExists(branch string) (bool, error) {
+	return backend.LocalBranchExists(branch)
 }
 
-func RemoteBranchExists(branch string) bool {
-	remoteBranch := shell.Check(shell.Execute(fmt.Sprintf("git ls-remote --heads origin %s", branch)))
+func RemoteBranchExists(branch string) (bool, error) {
+	heads, err := backend.RemoteHeads(branch)
 
-	return len(remoteBranch) > 0
+	return len(heads) > 0, err
 }
 
 func GitDuet() []string {
-	author, authorErr := shell.Execute("git config --get duet.env.git-author-initials")
-	coauthor, coauthorErr := shell.Execute("git config --get duet.env.git-committer-initials")
+	author, authorErr := output("config", "--get", "duet.env.git-author-initials")
+	coauthor, coauthorErr := output("config", "--get", "duet.env.git-committer-initials")
 
 	if authorErr != nil && coauthorErr != nil {
 		return []string{}
@@ -62,7 +56,7 @@ func GitDuet() []string {
 }
 
 func GitTogether() []string {
-	activeAuthors, err := shell.Execute("git config --get git-together.active")
+	activeAuthors, err := output("config", "--get", "git-together.active")
 
 	if err != nil {
 		return []string{}
@@ -72,48 +66,308 @@ func GitTogether() []string {
 }
 
 func GetCurrentBranch() (string, error) {
-	currentBranch, err := shell.Execute("git rev-parse --abbrev-ref HEAD")
+	return backend.CurrentBranch()
+}
+
+func GetRemoteTrackingBranch() (string, error) {
+	return backend.RemoteTrackingBranch()
+}
+
+func GetBoundarySha(remoteTrackingBranch string, currentBranch string) (string, error) {
+	return backend.BoundarySha(remoteTrackingBranch, currentBranch)
+}
+
+func HeadSha() (string, error) {
+	return backend.HeadSha()
+}
+
+func RevParse(ref string) (string, error) {
+	sha, err := line("rev-parse", "--verify", "--quiet", ref)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%s does not exist", ref)
 	}
-	cleanCurrentBranch := strings.TrimSuffix(currentBranch, "\n")
-	return cleanCurrentBranch, nil
+
+	return sha, nil
 }
 
-func GetRemoteTrackingBranch() (string, error) {
-	remoteTrackingBranch, err := shell.Execute("git rev-parse --abbrev-ref --symbolic-full-name @{u}")
+// RemoteHeadSha asks origin where branch is, it is empty when origin has no
+// such branch.
+func RemoteHeadSha(branch string) (string, error) {
+	heads, err := backend.RemoteHeads(branch)
+
+	return heads[branch], err
+}
+
+// RemoteBranches lists which of branches origin has.
+func RemoteBranches(branches ...string) ([]string, error) {
+	heads, err := backend.RemoteHeads(branches...)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	cleanRemoteTrackingBranch := strings.TrimSuffix(remoteTrackingBranch, "\n")
-	return cleanRemoteTrackingBranch, nil
+
+	return slices.Filter(branches, func(branch string) bool {
+		_, ok := heads[branch]
+		return ok
+	}), nil
 }
 
-func GetBoundarySha(remoteTrackingBranch string, currentBranch string) (string, error) {
-	revisionBoundaries, err := shell.Execute(fmt.Sprintf("git rev-list --boundary %s..%s", remoteTrackingBranch, currentBranch))
+// UnstagedChanges lists what `git add --all` would still stage, leaving out
+// changes inside submodules which it can't.
+func UnstagedChanges() ([]string, error) {
+	changed, err := output("diff", "--name-only", "--ignore-submodules=dirty")
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	untracked, err := output("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(splitLines(changed), splitLines(untracked)...), nil
+}
+
+// Changes lists the working tree's changes, leaving out submodules.
+func Changes() ([]string, error) {
+	status, err := output("status", "--porcelain=v1", "--ignore-submodules=all")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(status), nil
+}
+
+// ConflictedFiles are the paths left unmerged by a merge or rebase.
+func ConflictedFiles() ([]string, error) {
+	files, err := output("diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(files), nil
+}
+
+func TrackedFiles(pathspecs []string) ([]string, error) {
+	files, err := output(append([]string{"ls-files", "--cached", "--"}, pathspecs...)...)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(revisionBoundaries) > 0 {
-		return parseRefBoundary(revisionBoundaries), nil
-	} else {
-		currentRev, err := shell.Execute(fmt.Sprintf("git rev-parse %s", currentBranch))
+	return splitLines(files), nil
+}
+
+func StagedChanges() bool {
+	_, err := output("diff", "--cached", "--quiet")
+
+	return err != nil
+}
+
+func RebaseInProgress() bool {
+	for _, directory := range []string{"rebase-merge", "rebase-apply"} {
+		path, err := line("rev-parse", "--git-path", directory)
 		if err != nil {
-			return "", err
+			continue
 		}
 
-		cleanCurrentRev := strings.TrimSuffix(currentRev, "\n")
-		return cleanCurrentRev, nil
+		if _, err = os.Stat(path); err == nil {
+			return true
+		}
 	}
+
+	return false
 }
 
-func Fetch() (string, error) {
-	return shell.Execute("git fetch")
+func CommitMessage(ref string) (string, error) {
+	return output("log", ref, "--format=%B", "-n", "1")
+}
+
+func Fetch() error {
+	_, err := Run(context.Background(), Command{Args: []string{"fetch"}})
+
+	return err
+}
+
+// FetchDetached downloads branches as they are on origin without updating
+// any ref or FETCH_HEAD, for a look that changes nothing. It maps each of
+// branches that origin has to its sha.
+func FetchDetached(branches ...string) (map[string]string, error) {
+	heads, err := backend.RemoteHeads(branches...)
+	if err != nil || len(heads) == 0 {
+		return heads, err
+	}
+
+	args := []string{"fetch", "--quiet", "--no-write-fetch-head", "--refmap=", "origin"}
+	for _, branch := range branches {
+		if sha, ok := heads[branch]; ok {
+			args = append(args, sha)
+		}
+	}
+
+	_, err = output(args...)
+
+	return heads, err
 }
 
 func ShowCommitMessage(branch string) (string, error) {
-	return shell.Execute(fmt.Sprintf("git log origin/%s --format=%%B -n 1", branch))
+	return output("log", "origin/"+branch, "--format=%B", "-n", "1")
+}
+
+func StashList() ([]string, error) {
+	stashes, err := output("stash", "list", "--format=%H %gs")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(stashes), nil
+}
+
+// StashRef is the stash@{n} entry of the stash list at sha.
+func StashRef(sha string) (string, error) {
+	entries, err := output("stash", "list", "--format=%H")
+	if err != nil {
+		return "", err
+	}
+
+	for i, entry := range splitLines(entries) {
+		if entry == sha {
+			return fmt.Sprintf("stash@{%d}", i), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s is not in the stash list", sha)
+}
+
+// StashFiles are the paths a stash commit changed, its untracked files
+// included.
+func StashFiles(sha string) ([]string, error) {
+	tracked, err := output("diff", "--name-only", sha+"^1", sha)
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, err := StashUntrackedFiles(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(splitLines(tracked), untracked...), nil
+}
+
+// StashUntrackedFiles are the untracked files a stash made with
+// --include-untracked holds.
+func StashUntrackedFiles(sha string) ([]string, error) {
+	if _, err := line("rev-parse", "--verify", "--quiet", sha+"^3"); err != nil {
+		return nil, nil
+	}
+
+	files, err := output("ls-tree", "-r", "--name-only", sha+"^3")
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(files), nil
+}
+
+func IncludeIgnoredPatterns() []string {
+	return includeIgnoredPatterns("")
+}
+
+func includeIgnoredPatterns(dir string) []string {
+	patterns, err := output(in(dir, "config", "--get-all", "portal.includeIgnored")...)
+
+	if err != nil {
+		return []string{}
+	}
+
+	return splitLines(patterns)
+}
+
+func IgnoredFiles(patterns []string) ([]string, error) {
+	return ignoredFiles("", patterns)
+}
+
+func ignoredFiles(dir string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return []string{}, nil
+	}
+
+	pathspecs := []string{}
+	for _, pattern := range patterns {
+		pathspecs = append(pathspecs, fmt.Sprintf(":(top,glob)%s", pattern))
+	}
+
+	files, err := output(in(dir, append([]string{"ls-files", "--others", "--ignored", "--exclude-standard", "--full-name", "--"}, pathspecs...)...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(files), nil
+}
+
+// OverwrittenFiles lists which of files, relative to the top level, the
+// working tree has with other contents than the commit at ref. Git checks
+// ignored files out over the ones in the working tree without a word.
+func OverwrittenFiles(ref string, files []string) ([]string, error) {
+	topLevel, err := TopLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	overwritten := []string{}
+	for _, file := range files {
+		path := filepath.Join(topLevel, file)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		incoming, err := line("rev-parse", "--verify", "--quiet", fmt.Sprintf("%s:%s", ref, file))
+		if err != nil {
+			continue
+		}
+
+		current, err := line("hash-object", "--", path)
+		if err != nil {
+			return nil, err
+		}
+
+		if current != incoming {
+			overwritten = append(overwritten, file)
+		}
+	}
+
+	return overwritten, nil
+}
+
+func TopLevelPathspecs(files []string) []string {
+	return slices.Map(files, func(file string) string {
+		return fmt.Sprintf(":(top,literal)%s", file)
+	})
+}
+
+// parseRemoteHeads maps each of branches found in ls-remote's output to its
+// sha.
+func parseRemoteHeads(heads string, branches []string) map[string]string {
+	shas := map[string]string{}
+	for _, line := range splitLines(heads) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		for _, branch := range branches {
+			if fields[1] == "refs/heads/"+branch {
+				shas[branch] = fields[0]
+			}
+		}
+	}
+
+	return shas
+}
+
+func splitLines(output string) []string {
+	return strings.FieldsFunc(output, func(c rune) bool {
+		return c == '\n'
+	})
 }
 
 func parseRefBoundary(revisionBoundaries string) string {
@@ -123,3 +377,37 @@ func parseRefBoundary(revisionBoundaries string) string {
 
 	return char.TrimFirstRune(boundaries[len(boundaries)-1])
 }
+
+// StateDir is where portal keeps what it records about a worktree, each
+// linked worktree having its own.
+func StateDir() (string, error) {
+	worktree, err := CurrentWorktree()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(worktree.GitDir, "portal"), nil
+}
+
+// Refs maps HEAD and each existing ref to the sha it points at.
+func Refs(refs ...string) (map[string]string, error) {
+	shas := map[string]string{}
+
+	if head, err := HeadSha(); err == nil {
+		shas["HEAD"] = head
+	}
+
+	listed, err := output(append([]string{"for-each-ref", "--format=%(refname) %(objectname)"}, refs...)...)
+	if err != nil {
+		return shas, err
+	}
+
+	for _, line := range splitLines(listed) {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 {
+			shas[fields[0]] = fields[1]
+		}
+	}
+
+	return shas, nil
+}
@@ -1,9 +1,12 @@
 package git
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/ericTsiliacos/portal/internal/shell"
 )
 
 func TestGetRefBoundary(t *testing.T) {
@@ -15,3 +18,72 @@ func TestGetRefBoundary(t *testing.T) {
 	actual = parseRefBoundary(revisionBoundaries)
 	assert.Equal(t, actual, "b90012997091b1dd3f2987f6495cc9b203fed291")
 }
+
+func TestParseDirtySubmodules(t *testing.T) {
+	status := "1 .M S.M. 160000 160000 160000 abc abc library\x00" +
+		"1 .M N... 100644 100644 100644 abc abc lib dir/file.txt\x00" +
+		"2 R. S..U 160000 160000 160000 abc abc R100 vendor/new name\x00vendor/old\x00" +
+		"1 .M SC.. 160000 160000 160000 abc def moved\x00" +
+		"? untracked.txt\x00"
+
+	actual := parseDirtySubmodules(status)
+	assert.Equal(t, []string{"library", "vendor/new name", "moved"}, actual)
+}
+
+func TestParseRemoteHeads(t *testing.T) {
+	heads := "4980d711afd8b8376d0404229bf1bb40b046247e\trefs/heads/tmp/portal/fp-op\n" +
+		"b90012997091b1dd3f2987f6495cc9b203fed291\trefs/heads/tmp/portal/fp-op-stash\n"
+
+	actual := parseRemoteHeads(heads, []string{"fp-op", "tmp/portal/fp-op", "tmp/portal/fp-op-stash"})
+	assert.Equal(t, map[string]string{
+		"tmp/portal/fp-op":       "4980d711afd8b8376d0404229bf1bb40b046247e",
+		"tmp/portal/fp-op-stash": "b90012997091b1dd3f2987f6495cc9b203fed291",
+	}, actual)
+}
+
+func TestTransient(t *testing.T) {
+	hungUp := &shell.CommandError{
+		Command: "git push origin pa-ir --progress",
+		Output:  "error: RPC failed; curl 56 GnuTLS recv error (-9)\nfatal: the remote end hung up unexpectedly\n",
+		Err:     errors.New("exit status 128"),
+	}
+	rejected := &shell.CommandError{
+		Command: "git push origin pa-ir --progress",
+		Output:  " ! [rejected]        pa-ir -> pa-ir (fetch first)\n",
+		Err:     errors.New("exit status 1"),
+	}
+
+	assert.True(t, Transient(hungUp))
+	assert.False(t, Transient(rejected))
+	assert.False(t, Transient(errors.New("connection reset by peer")))
+}
+
+func TestParseMergeTree(t *testing.T) {
+	conflicted := "e21ac99cfc836b7f1991d23d493d8186ff2ee2d0\nx\nlib dir/y\n\n" +
+		"Auto-merging x\nCONFLICT (content): Merge conflict in x\n"
+
+	actual := parseMergeTree(conflicted)
+	assert.Equal(t, Merge{
+		Tree:      "e21ac99cfc836b7f1991d23d493d8186ff2ee2d0",
+		Conflicts: []string{"x", "lib dir/y"},
+		Messages:  []string{"Auto-merging x", "CONFLICT (content): Merge conflict in x"},
+	}, actual)
+
+	actual = parseMergeTree("782f13f050156b85406cbf38c09ac52208ecea04\n")
+	assert.Equal(t, Merge{Tree: "782f13f050156b85406cbf38c09ac52208ecea04", Conflicts: []string{}}, actual)
+}
+
+func TestParseVersion(t *testing.T) {
+	for version, expected := range map[string][2]int{
+		"git version 2.39.5":                   {2, 39},
+		"git version 2.37.1 (Apple Git-137.1)": {2, 37},
+		"git version 2.40.0.windows.1":         {2, 40},
+	} {
+		major, minor, err := parseVersion(version)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, [2]int{major, minor}, version)
+	}
+
+	_, _, err := parseVersion("hub version 2.14.2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// goGitBackend reads the repository in process. Asking origin for its
+// branches is left to git, go-git's transports only authenticating over ssh
+// with an ssh agent, not with credential helpers.
+type goGitBackend struct{}
+
+func (goGitBackend) open() (*gogit.Repository, error) {
+	return gogit.PlainOpenWithOptions(".", &gogit.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+}
+
+func (b goGitBackend) CurrentBranch() (string, error) {
+	repository, err := b.open()
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if !head.Name().IsBranch() {
+		return "HEAD", nil
+	}
+
+	return head.Name().Short(), nil
+}
+
+func (b goGitBackend) RemoteTrackingBranch() (string, error) {
+	repository, err := b.open()
+	if err != nil {
+		return "", err
+	}
+
+	upstream, err := b.upstream(repository)
+	if err != nil {
+		return "", err
+	}
+
+	return upstream.Short(), nil
+}
+
+// upstream is the remote tracking ref of the current branch.
+func (b goGitBackend) upstream(repository *gogit.Repository) (plumbing.ReferenceName, error) {
+	branch, err := b.CurrentBranch()
+	if err != nil {
+		return "", err
+	}
+
+	config, err := repository.Config()
+	if err != nil {
+		return "", err
+	}
+
+	tracking, ok := config.Branches[branch]
+	if !ok || tracking.Remote == "" || tracking.Merge == "" {
+		return "", fmt.Errorf("no upstream configured for branch '%s'", branch)
+	}
+
+	if tracking.Remote == "." {
+		return tracking.Merge, nil
+	}
+
+	return plumbing.NewRemoteReferenceName(tracking.Remote, tracking.Merge.Short()), nil
+}
+
+func (b goGitBackend) HeadSha() (string, error) {
+	repository, err := b.open()
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Hash().String(), nil
+}
+
+func (b goGitBackend) BoundarySha(remoteTrackingBranch string, currentBranch string) (string, error) {
+	repository, err := b.open()
+	if err != nil {
+		return "", err
+	}
+
+	current, err := commit(repository, currentBranch)
+	if err != nil {
+		return "", err
+	}
+
+	remote, err := commit(repository, remoteTrackingBranch)
+	if err != nil {
+		return "", err
+	}
+
+	published, err := contains(remote, current)
+	if err != nil || published {
+		return current.Hash.String(), err
+	}
+
+	bases, err := current.MergeBase(remote)
+	if err != nil {
+		return "", err
+	}
+
+	if len(bases) == 0 {
+		return "", fmt.Errorf("%s and %s have no common history", currentBranch, remoteTrackingBranch)
+	}
+
+	return bases[0].Hash.String(), nil
+}
+
+func (b goGitBackend) DirtyIndex() (bool, error) {
+	repository, err := b.open()
+	if err != nil {
+		return false, err
+	}
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return false, err
+	}
+
+	return !status.IsClean(), nil
+}
+
+func (b goGitBackend) UnpublishedWork() (bool, error) {
+	repository, err := b.open()
+	if err != nil {
+		return false, err
+	}
+
+	upstream, err := b.upstream(repository)
+	if err != nil {
+		return false, nil
+	}
+
+	head, err := commit(repository, "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	remote, err := commit(repository, upstream.String())
+	if err != nil {
+		return false, nil
+	}
+
+	published, err := contains(remote, head)
+
+	return !published, err
+}
+
+func (b goGitBackend) LocalBranchExists(branch string) (bool, error) {
+	repository, err := b.open()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = repository.Reference(plumbing.NewBranchReferenceName(branch), false)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// RemoteHeads asks origin through git, whose credential helpers go-git's
+// transports don't use.
+func (goGitBackend) RemoteHeads(branches ...string) (map[string]string, error) {
+	return execBackend{}.RemoteHeads(branches...)
+}
+
+func commit(repository *gogit.Repository, revision string) (*object.Commit, error) {
+	hash, err := repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", revision, err)
+	}
+
+	return repository.CommitObject(*hash)
+}
+
+// contains reports whether commit is in the history of head.
+func contains(head *object.Commit, commit *object.Commit) (bool, error) {
+	if head.Hash == commit.Hash {
+		return true, nil
+	}
+
+	return commit.IsAncestor(head)
+}
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ericTsiliacos/portal/internal/shell"
+)
+
+// HookError is a hook that exited non-zero, with everything it printed.
+type HookError struct {
+	Hook   string
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HookError) CommandOutput() string {
+	return e.Output
+}
+
+// Hook is the path of the named hook, empty when the repository has no
+// such executable hook.
+func Hook(name string) (string, error) {
+	topLevel, err := TopLevel()
+	if err != nil {
+		return "", err
+	}
+
+	// a relative core.hooksPath is relative to where hooks run
+	hooks, err := line("config", "--path", "core.hooksPath")
+	if err == nil && hooks != "" && !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(topLevel, hooks)
+	}
+
+	if err != nil || hooks == "" {
+		if hooks, err = gitPath("hooks"); err != nil {
+			return "", err
+		}
+	}
+
+	path := filepath.Join(hooks, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return "", nil
+	}
+
+	return path, nil
+}
+
+// RunHook runs the named hook the way git commit would, from the top of the
+// worktree and against its index. A repository without the hook passes.
+func RunHook(ctx context.Context, name string, verbose bool, args ...string) error {
+	path, err := Hook(name)
+	if err != nil || path == "" {
+		return err
+	}
+
+	topLevel, err := TopLevel()
+	if err != nil {
+		return err
+	}
+
+	index, err := gitPath("index")
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = topLevel
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+index, "GIT_EDITOR=:")
+
+	if _, _, err = shell.RunContext(ctx, cmd, verbose); err != nil {
+		hookError := &HookError{Hook: name, Err: err}
+
+		var commandError *shell.CommandError
+		if errors.As(err, &commandError) {
+			hookError.Output = commandError.Output
+			hookError.Err = commandError.Err
+		}
+
+		return hookError
+	}
+
+	return nil
+}
+
+// gitPath is the absolute path of a file in the git directory.
+func gitPath(name string) (string, error) {
+	path, err := line("rev-parse", "--git-path", name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(path)
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/shell"
+)
+
+// Command is a git invocation. Args are handed to git as they are, without
+// the leading "git", so nothing is ever split on spaces.
+type Command struct {
+	Args []string
+
+	// Dir is where git runs, the current directory when empty.
+	Dir string
+
+	// Env is added to portal's own environment.
+	Env []string
+
+	// Stdin is fed to git when it isn't empty.
+	Stdin string
+
+	// Verbose prints the command and its output.
+	Verbose bool
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{"git"}, c.Args...), " ")
+}
+
+// Result is what a command wrote and how it exited.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Runner runs git commands. A command that fails returns a
+// *shell.CommandError along with whatever it wrote.
+type Runner interface {
+	Run(ctx context.Context, command Command) (Result, error)
+}
+
+// ExecRunner runs the git binary.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, command Command) (Result, error) {
+	cmd := exec.CommandContext(ctx, "git", command.Args...)
+	cmd.Dir = command.Dir
+	if len(command.Env) > 0 {
+		cmd.Env = append(os.Environ(), command.Env...)
+	}
+	if command.Stdin != "" {
+		cmd.Stdin = strings.NewReader(command.Stdin)
+	}
+
+	stdout, stderr, err := shell.RunContext(ctx, cmd, command.Verbose)
+
+	return Result{Stdout: stdout, Stderr: stderr, ExitCode: shell.ExitCode(err)}, err
+}
+
+var runner Runner = ExecRunner{}
+
+// SetRunner makes r run every git command from now on, and returns the runner
+// it replaces.
+func SetRunner(r Runner) Runner {
+	previous := runner
+	runner = r
+
+	return previous
+}
+
+// Run runs command with the current runner.
+func Run(ctx context.Context, command Command) (Result, error) {
+	return runner.Run(ctx, command)
+}
+
+// output runs git with args and returns its stdout.
+func output(args ...string) (string, error) {
+	result, err := Run(context.Background(), Command{Args: args})
+
+	return result.Stdout, err
+}
+
+// line is output without the trailing newline.
+func line(args ...string) (string, error) {
+	out, err := output(args...)
+
+	return strings.TrimSuffix(out, "\n"), err
+}
@@ -0,0 +1,126 @@
+package git
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fake(t *testing.T) *FakeRunner {
+	f := &FakeRunner{}
+	previous := SetRunner(f)
+	t.Cleanup(func() { SetRunner(previous) })
+
+	return f
+}
+
+func TestArgsAreNotSplitOnSpaces(t *testing.T) {
+	f := fake(t)
+	f.On("branch", "--list").Returns("  pair branch\n")
+
+	exists, err := LocalBranchExists("pair branch")
+
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"git branch --list pair branch"}, f.Ran())
+}
+
+func TestFailuresAreReturned(t *testing.T) {
+	f := fake(t)
+	f.On("status").Fails(128, "fatal: not a git repository\n")
+
+	_, err := DirtyIndex()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "git status --porcelain=v1")
+}
+
+func TestLatestScriptWins(t *testing.T) {
+	f := fake(t)
+	f.On("rev-parse").Returns("abc\n")
+	f.On("rev-parse", "--abbrev-ref", "HEAD").Returns("pairing\n")
+
+	branch, _ := GetCurrentBranch()
+	sha, _ := HeadSha()
+
+	assert.Equal(t, "pairing", branch)
+	assert.Equal(t, "abc", sha)
+}
+
+func TestFakeFailuresAreTransient(t *testing.T) {
+	f := fake(t)
+	f.On("fetch").Fails(128, "fatal: the remote end hung up unexpectedly\n")
+
+	err := Fetch()
+
+	assert.True(t, Transient(err))
+}
+
+func TestUnscriptedCommandsFail(t *testing.T) {
+	fake(t)
+
+	result, err := Run(context.Background(), Command{Args: []string{"gc"}})
+
+	assert.Error(t, err)
+	assert.Equal(t, -1, result.ExitCode)
+}
+
+func TestRemoteHeadSha(t *testing.T) {
+	f := fake(t)
+	f.On("ls-remote", "--heads", "origin", "refs/heads/pa-ir").Returns("4980d711afd8b8376d0404229bf1bb40b046247e\trefs/heads/pa-ir\n")
+	f.On("ls-remote", "--heads", "origin", "refs/heads/gone").Returns("")
+
+	sha, _ := RemoteHeadSha("pa-ir")
+	missing, _ := RemoteHeadSha("gone")
+
+	assert.Equal(t, "4980d711afd8b8376d0404229bf1bb40b046247e", sha)
+	assert.Equal(t, "", missing)
+}
+
+func TestFetchDetachedFetchesShas(t *testing.T) {
+	f := fake(t)
+	f.On("ls-remote", "--heads", "origin", "refs/heads/pa-ir").Returns("4980d711afd8b8376d0404229bf1bb40b046247e\trefs/heads/pa-ir\n")
+	f.On("fetch").Fails(128, "fatal: the remote end hung up unexpectedly\n")
+
+	heads, err := FetchDetached("pa-ir")
+
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{"pa-ir": "4980d711afd8b8376d0404229bf1bb40b046247e"}, heads)
+	assert.Equal(t, "git fetch --quiet --no-write-fetch-head --refmap= origin 4980d711afd8b8376d0404229bf1bb40b046247e", f.Ran()[1])
+}
+
+func TestAnswersFollowEarlierCommands(t *testing.T) {
+	f := fake(t)
+	branch := "main"
+	f.On("checkout").Answers(func(args []string) string {
+		branch = args[1]
+		return ""
+	})
+	f.On("rev-parse", "--abbrev-ref", "HEAD").Answers(func([]string) string {
+		return branch + "\n"
+	})
+
+	before, _ := GetCurrentBranch()
+	_, err := Run(context.Background(), Command{Args: []string{"checkout", "pa-ir"}})
+	after, _ := GetCurrentBranch()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "main", before)
+	assert.Equal(t, "pa-ir", after)
+}
+
+func TestMergeTreesNeedsNoIdentity(t *testing.T) {
+	f := fake(t)
+	f.On("commit-tree").Returns("4980d711afd8b8376d0404229bf1bb40b046247e\n")
+	f.On("merge-tree").Returns("0d1f6eb4b4b0ea9a3a1c7e1d4de0e0a0b5f5e1c2\n")
+
+	_, err := MergeTrees("base", "ours", "theirs")
+
+	assert.NoError(t, err)
+	for _, command := range f.commands[:2] {
+		assert.Equal(t, "commit-tree", command.Args[0])
+		assert.Contains(t, command.Env, "GIT_AUTHOR_NAME=portal")
+		assert.Contains(t, command.Env, "GIT_COMMITTER_EMAIL=portal@localhost")
+	}
+}
@@ -0,0 +1,130 @@
+package git
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+func TopLevel() (string, error) {
+	return line("rev-parse", "--show-toplevel")
+}
+
+func RecurseSubmodules() bool {
+	recurse, err := output("config", "--type=bool", "portal.recurseSubmodules")
+
+	return err != nil || strings.TrimSuffix(recurse, "\n") != "false"
+}
+
+// DirtySubmodules lists submodules, nested ones included, whose HEAD moved
+// from the commit their superproject records or whose worktree or index
+// differs from their HEAD. Paths are relative to the top level and
+// parents are always listed before their children.
+func DirtySubmodules() ([]string, error) {
+	topLevel, err := TopLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	return dirtySubmodules(topLevel, "")
+}
+
+func SubmoduleHead(topLevel string, path string) (string, error) {
+	result, err := Run(context.Background(), Command{Args: []string{"rev-parse", "HEAD"}, Dir: filepath.Join(topLevel, path)})
+
+	return strings.TrimSuffix(result.Stdout, "\n"), err
+}
+
+// Gitlink is the commit the HEAD of the repository in dir records for the
+// submodule at path.
+func Gitlink(dir string, path string) (string, error) {
+	result, err := Run(context.Background(), Command{Args: []string{"rev-parse", "HEAD:" + filepath.ToSlash(path)}, Dir: dir})
+
+	return strings.TrimSuffix(result.Stdout, "\n"), err
+}
+
+func SubmodulePublished(topLevel string, path string, sha string) bool {
+	remoteBranches, err := Run(context.Background(), Command{Args: []string{"branch", "--remotes", "--contains", sha}, Dir: filepath.Join(topLevel, path)})
+
+	return err == nil && len(remoteBranches.Stdout) > 0
+}
+
+func SubmoduleIndexPatch(topLevel string, path string) (string, error) {
+	patch, err := Run(context.Background(), Command{Args: []string{"diff", "--cached", "--binary", "--ignore-submodules=all", "HEAD"}, Dir: filepath.Join(topLevel, path)})
+
+	return patch.Stdout, err
+}
+
+// SubmoduleWorktreePatch diffs the whole worktree, untracked files included,
+// against HEAD by staging it into a throwaway index.
+func SubmoduleWorktreePatch(topLevel string, path string) (patch string, err error) {
+	submodulePath := filepath.Join(topLevel, path)
+
+	err = withTemporaryIndex("", func(env []string) (err error) {
+		add := Command{Args: []string{"add", "--all"}, Dir: submodulePath, Env: env}
+		if _, err = Run(context.Background(), add); err != nil {
+			return
+		}
+
+		diff := Command{Args: []string{"diff", "--cached", "--binary", "--ignore-submodules=all", "HEAD"}, Dir: submodulePath, Env: env}
+		result, err := Run(context.Background(), diff)
+		patch = result.Stdout
+		return
+	})
+
+	return
+}
+
+func dirtySubmodules(topLevel string, path string) ([]string, error) {
+	result, err := Run(context.Background(), Command{Args: []string{"status", "--porcelain=v2", "-z"}, Dir: filepath.Join(topLevel, path)})
+	if err != nil {
+		return nil, err
+	}
+	status := result.Stdout
+
+	dirty := []string{}
+	for _, submodule := range parseDirtySubmodules(status) {
+		submodulePath := filepath.ToSlash(filepath.Join(path, submodule))
+		dirty = append(dirty, submodulePath)
+
+		nested, err := dirtySubmodules(topLevel, submodulePath)
+		if err != nil {
+			return nil, err
+		}
+		dirty = append(dirty, nested...)
+	}
+
+	return dirty, nil
+}
+
+func parseDirtySubmodules(status string) []string {
+	submodules := []string{}
+
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+
+		var fieldCount int
+		switch {
+		case strings.HasPrefix(entry, "1 "):
+			fieldCount = 9
+		case strings.HasPrefix(entry, "2 "):
+			fieldCount = 10
+			i++
+		default:
+			continue
+		}
+
+		fields := strings.SplitN(entry, " ", fieldCount)
+		if len(fields) < fieldCount {
+			continue
+		}
+
+		submoduleState := fields[2]
+		if strings.HasPrefix(submoduleState, "S") && (submoduleState[1] == 'C' || submoduleState[2] == 'M' || submoduleState[3] == 'U') {
+			submodules = append(submodules, fields[fieldCount-1])
+		}
+	}
+
+	return submodules
+}
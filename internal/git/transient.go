@@ -0,0 +1,45 @@
+package git
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/shell"
+)
+
+// transientFailures are stderr fragments of git failures caused by the
+// network or the remote host rather than by the repository.
+var transientFailures = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"operation timed out",
+	"rpc failed",
+	"the remote end hung up",
+	"early eof",
+	"unexpected disconnect",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"gnutls_handshake() failed",
+	"ssl_read",
+	"the requested url returned error: 502",
+	"the requested url returned error: 503",
+	"the requested url returned error: 504",
+}
+
+// Transient reports whether a failed git command is worth retrying.
+func Transient(err error) bool {
+	var commandError *shell.CommandError
+	if !errors.As(err, &commandError) {
+		return false
+	}
+
+	output := strings.ToLower(commandError.Output)
+	for _, failure := range transientFailures {
+		if strings.Contains(output, failure) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func WriteTree() (string, error) {
+	return line("write-tree")
+}
+
+// WorktreeTree hashes the whole worktree, untracked files and the given
+// ignored files included, without touching the real index.
+func WorktreeTree(ignoredFiles []string) (string, error) {
+	return worktreeTree("", ignoredFiles)
+}
+
+// worktreeTree is WorktreeTree for the repository in dir. The throwaway
+// index starts as a copy of the real one, whose stat data spares hashing the
+// files that haven't changed again.
+func worktreeTree(dir string, ignoredFiles []string) (tree string, err error) {
+	index, err := indexFile(dir)
+	if err != nil {
+		return
+	}
+
+	err = withTemporaryIndex(index, func(env []string) (err error) {
+		add := Command{Args: []string{"add", "--all"}, Dir: dir, Env: env}
+		if _, err = Run(context.Background(), add); err != nil {
+			return
+		}
+
+		if len(ignoredFiles) > 0 {
+			forceAdd := Command{Args: append([]string{"add", "--force", "--"}, TopLevelPathspecs(ignoredFiles)...), Dir: dir, Env: env}
+			if _, err = Run(context.Background(), forceAdd); err != nil {
+				return
+			}
+		}
+
+		result, err := Run(context.Background(), Command{Args: []string{"write-tree"}, Dir: dir, Env: env})
+		tree = strings.TrimSuffix(result.Stdout, "\n")
+		return
+	})
+
+	return
+}
+
+// indexFile is where the index of the repository in dir is.
+func indexFile(dir string) (string, error) {
+	result, err := Run(context.Background(), Command{Args: []string{"rev-parse", "--git-path", "index"}, Dir: dir})
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSuffix(result.Stdout, "\n")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	return path, nil
+}
+
+func DiffTrees(expected string, actual string) ([]string, error) {
+	diff, err := output("diff-tree", "-r", "--name-status", expected, actual)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(diff), nil
+}
+
+// ChangedFiles are the paths that differ between two commits.
+func ChangedFiles(from string, to string) ([]string, error) {
+	diff, err := output("diff", "--name-only", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitLines(diff), nil
+}
+
+// CommitsBetween counts the commits reachable from to but not from.
+func CommitsBetween(from string, to string) (int, error) {
+	count, err := line("rev-list", "--count", fmt.Sprintf("%s..%s", from, to))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(count)
+}
+
+// Merge is the outcome of a merge done in memory.
+type Merge struct {
+	Tree      string
+	Conflicts []string
+
+	// Messages are what git says about each file it had to merge.
+	Messages []string
+}
+
+// throwawayIdentity authors commits that are never kept, so that making
+// them doesn't need an identity configured.
+var throwawayIdentity = []string{
+	"GIT_AUTHOR_NAME=portal",
+	"GIT_AUTHOR_EMAIL=portal@localhost",
+	"GIT_COMMITTER_NAME=portal",
+	"GIT_COMMITTER_EMAIL=portal@localhost",
+}
+
+// MergeTrees merges the changes from base to theirs into ours without
+// touching HEAD, the index or the working tree. ours and theirs are each
+// committed again on top of base, so that base is the merge base whatever
+// their history, as a rebase --onto would have it.
+func MergeTrees(base string, ours string, theirs string) (Merge, error) {
+	var parents []string
+	for _, side := range []string{ours, theirs} {
+		commit := Command{Args: []string{"commit-tree", side + "^{tree}", "-p", base, "-m", "portal merge"}, Env: throwawayIdentity}
+		result, err := Run(context.Background(), commit)
+		if err != nil {
+			return Merge{}, err
+		}
+		parents = append(parents, strings.TrimSuffix(result.Stdout, "\n"))
+	}
+
+	result, err := Run(context.Background(), Command{Args: []string{"merge-tree", "--write-tree", "--name-only", parents[0], parents[1]}})
+	// merge-tree exits 1 when the merge has conflicts
+	if err != nil && result.ExitCode != 1 {
+		return Merge{}, err
+	}
+
+	return parseMergeTree(result.Stdout), nil
+}
+
+// MergeTreesSupported is whether git can merge in memory, which
+// merge-tree --write-tree needs git 2.38 or later for.
+func MergeTreesSupported() (bool, error) {
+	version, err := line("version")
+	if err != nil {
+		return false, err
+	}
+
+	major, minor, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	return major > 2 || major == 2 && minor >= 38, nil
+}
+
+// parseVersion reads the major and minor version out of git version's
+// output, e.g. "git version 2.39.5" or "git version 2.40.0.windows.1".
+func parseVersion(version string) (major int, minor int, err error) {
+	fields := strings.Fields(version)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return 0, 0, fmt.Errorf("unknown git version %q", version)
+	}
+
+	numbers := strings.SplitN(fields[2], ".", 3)
+	if len(numbers) < 2 {
+		return 0, 0, fmt.Errorf("unknown git version %q", version)
+	}
+
+	if major, err = strconv.Atoi(numbers[0]); err != nil {
+		return 0, 0, fmt.Errorf("unknown git version %q", version)
+	}
+	if minor, err = strconv.Atoi(numbers[1]); err != nil {
+		return 0, 0, fmt.Errorf("unknown git version %q", version)
+	}
+
+	return major, minor, nil
+}
+
+// parseMergeTree reads merge-tree's output: the tree, the conflicted files
+// and, after a blank line, its messages.
+func parseMergeTree(output string) Merge {
+	sections := strings.SplitN(output, "\n\n", 2)
+	files := splitLines(sections[0])
+
+	merge := Merge{Conflicts: []string{}}
+	if len(files) > 0 {
+		merge.Tree, merge.Conflicts = files[0], files[1:]
+	}
+	if len(sections) > 1 {
+		merge.Messages = splitLines(sections[1])
+	}
+
+	return merge
+}
+
+// withTemporaryIndex runs fn with an index of its own, a copy of seed or,
+// when seed is empty or missing, an empty one.
+func withTemporaryIndex(seed string, fn func(env []string) error) error {
+	indexFile, err := ioutil.TempFile("", "portal-index")
+	if err != nil {
+		return err
+	}
+	indexFile.Close()
+	os.Remove(indexFile.Name())
+	defer os.Remove(indexFile.Name())
+
+	if seed != "" {
+		index, err := ioutil.ReadFile(seed)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := ioutil.WriteFile(indexFile.Name(), index, 0600); err != nil {
+				return err
+			}
+		}
+	}
+
+	return fn([]string{fmt.Sprintf("GIT_INDEX_FILE=%s", indexFile.Name())})
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// Worktree is the checkout portal runs in. In a linked worktree GitDir is
+// the worktree's own directory under CommonDir, which holds the refs,
+// objects and config every worktree of the repository shares.
+type Worktree struct {
+	TopLevel  string
+	GitDir    string
+	CommonDir string
+}
+
+// Linked reports whether this is a worktree added with `git worktree add`.
+func (w Worktree) Linked() bool {
+	return w.GitDir != w.CommonDir
+}
+
+// backupRefs is where the worktree's backups are kept. Refs are shared by
+// every worktree of the repository, so a linked worktree keeps its backups
+// apart from the main worktree's, under its name.
+func (w Worktree) backupRefs() string {
+	if w.Linked() {
+		return backupRefs + "worktrees/" + filepath.Base(w.GitDir) + "/"
+	}
+
+	return backupRefs + "main/"
+}
+
+func CurrentWorktree() (Worktree, error) {
+	return worktreeIn("")
+}
+
+// worktreeIn is the worktree of the repository in dir, the current one when
+// empty.
+func worktreeIn(dir string) (Worktree, error) {
+	out, err := output(in(dir, "rev-parse", "--show-toplevel", "--absolute-git-dir", "--git-common-dir")...)
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	paths := splitLines(out)
+	if len(paths) != 3 {
+		return Worktree{}, errors.New("not inside a worktree")
+	}
+
+	gitDir, err := filepath.EvalSymlinks(paths[1])
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	// the common dir is relative to dir unless it's elsewhere
+	if !filepath.IsAbs(paths[2]) {
+		paths[2] = filepath.Join(dir, paths[2])
+	}
+
+	commonDir, err := filepath.EvalSymlinks(paths[2])
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	commonDir, err = filepath.Abs(commonDir)
+
+	return Worktree{TopLevel: paths[0], GitDir: gitDir, CommonDir: commonDir}, err
+}
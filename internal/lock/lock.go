@@ -0,0 +1,154 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Holder is the process a lock file names.
+type Holder struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Command  string    `json:"command"`
+	Since    time.Time `json:"since"`
+}
+
+func (h Holder) same(other Holder) bool {
+	return h.PID == other.PID && h.Hostname == other.Hostname && h.Since.Equal(other.Since)
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("portal %s (pid %d on %s, since %s)", h.Command, h.PID, h.Hostname, h.Since.Format(time.Kitchen))
+}
+
+// HeldError is a lock another live process holds, or one whose holder can't
+// be told apart from a live one.
+type HeldError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is already running in this repository. If it isn't, remove %s", e.Holder, e.Path)
+}
+
+// Lock is an exclusive lock file, held from Acquire until Release.
+type Lock struct {
+	path   string
+	holder Holder
+}
+
+// Acquire creates the lock file at path for this process running command.
+// A lock left by a process of this host that is no longer running is stale
+// and taken over, one from another host is always taken as held.
+func Acquire(path string, command string) (*Lock, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{path: path, holder: Holder{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		Command:  command,
+		Since:    time.Now(),
+	}}
+
+	err = guarded(path, func() error {
+		holder, err := Read(path)
+		if err == nil && !lock.stale(holder) {
+			return &HeldError{Path: path, Holder: holder}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return lock.create()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock is only ours if the file says so
+	holder, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if !holder.same(lock.holder) {
+		return nil, &HeldError{Path: path, Holder: holder}
+	}
+
+	return lock, nil
+}
+
+// Read is the holder named in the lock file at path.
+func Read(path string) (Holder, error) {
+	holder := Holder{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return holder, err
+	}
+
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return holder, fmt.Errorf("unreadable lock %s: %w", path, err)
+	}
+
+	return holder, nil
+}
+
+// Release removes the lock file, unless it has been taken over since.
+func (l *Lock) Release() error {
+	return guarded(l.path, func() error {
+		holder, err := Read(l.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !holder.same(l.holder) {
+			return nil
+		}
+
+		return os.Remove(l.path)
+	})
+}
+
+// create writes the holder to a file of its own and links it in place, so
+// that the lock file never exists half written.
+func (l *Lock) create() error {
+	file, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	data, err := json.Marshal(l.holder)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Link(file.Name(), l.path)
+}
+
+func (l *Lock) stale(holder Holder) bool {
+	return holder.Hostname == l.holder.Hostname && !running(holder.PID)
+}
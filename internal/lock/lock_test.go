@@ -0,0 +1,126 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockIsExclusive(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "portal.lock")
+
+	lock, err := Acquire(path, "push")
+	assert.NoError(t, err)
+
+	_, err = Acquire(path, "pull")
+	var held *HeldError
+	assert.True(t, errors.As(err, &held))
+	assert.Equal(t, os.Getpid(), held.Holder.PID)
+	assert.Equal(t, "push", held.Holder.Command)
+	assert.Contains(t, err.Error(), "portal push")
+	assert.Contains(t, err.Error(), path)
+
+	assert.NoError(t, lock.Release())
+	assert.NoFileExists(t, path)
+
+	lock, err = Acquire(path, "pull")
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
+
+func TestLockTakesOverStaleLocks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "portal.lock")
+
+	hostname, _ := os.Hostname()
+	writeHolder(t, path, Holder{PID: finishedProcess(t), Hostname: hostname, Command: "push"})
+
+	lock, err := Acquire(path, "pull")
+	assert.NoError(t, err)
+
+	holder, err := Read(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), holder.PID)
+	assert.Equal(t, "pull", holder.Command)
+	assert.NoError(t, lock.Release())
+}
+
+func TestLockTakesOverAStaleLockOnce(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "portal.lock")
+
+	hostname, _ := os.Hostname()
+	writeHolder(t, path, Holder{PID: finishedProcess(t), Hostname: hostname, Command: "push"})
+
+	acquired := make(chan *Lock, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(acquired); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if lock, err := Acquire(path, "pull"); err == nil {
+				acquired <- lock
+			}
+		}()
+	}
+	wg.Wait()
+	close(acquired)
+
+	assert.Len(t, acquired, 1)
+	assert.NoError(t, (<-acquired).Release())
+	assert.NoFileExists(t, path)
+}
+
+func TestLockFromAnotherHostIsHeld(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "portal.lock")
+
+	writeHolder(t, path, Holder{PID: finishedProcess(t), Hostname: "elsewhere", Command: "push"})
+
+	_, err := Acquire(path, "pull")
+	var held *HeldError
+	assert.True(t, errors.As(err, &held))
+	assert.Equal(t, "elsewhere", held.Holder.Hostname)
+}
+
+func TestReleaseLeavesALockTakenOver(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "portal.lock")
+
+	lock, err := Acquire(path, "push")
+	assert.NoError(t, err)
+
+	writeHolder(t, path, Holder{PID: os.Getpid(), Hostname: "elsewhere", Command: "pull"})
+
+	assert.NoError(t, lock.Release())
+	assert.FileExists(t, path)
+}
+
+func writeHolder(t *testing.T, path string, holder Holder) {
+	holder.Since = time.Now()
+	data, err := json.Marshal(holder)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, data, 0660))
+}
+
+// finishedProcess is the pid of a process that has exited.
+func finishedProcess(t *testing.T) int {
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+
+	return cmd.Process.Pid
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// guarded runs fn holding an flock on a file beside the lock, so that no two
+// processes look at and take over the lock at once. The kernel drops the
+// flock of a process that dies.
+func guarded(path string, fn func() error) error {
+	guard, err := os.OpenFile(path+".guard", os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+
+	if err := syscall.Flock(int(guard.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(guard.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// running is whether a process with pid exists, signal 0 checking that it
+// could be signalled without sending anything.
+func running(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
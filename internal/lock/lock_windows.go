@@ -0,0 +1,54 @@
+//go:build windows
+// +build windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code GetExitCodeProcess gives a process that
+// hasn't exited.
+const stillActive = 259
+
+// guarded runs fn holding a LockFileEx lock on a file beside the lock, so
+// that no two processes look at and take over the lock at once. Windows
+// drops the lock of a process that dies.
+func guarded(path string, fn func() error) error {
+	guard, err := os.OpenFile(path+".guard", os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+
+	handle := windows.Handle(guard.Fd())
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		return err
+	}
+	defer windows.UnlockFileEx(handle, 0, 1, 0, &windows.Overlapped{})
+
+	return fn()
+}
+
+// running is whether a process with pid exists and hasn't exited, one we
+// aren't allowed to look at counting as running.
+func running(pid int) bool {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(process)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(process, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
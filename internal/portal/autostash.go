@@ -0,0 +1,164 @@
+package portal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+const autostashMessage = "portal autostash"
+
+// Autostash is what a pull stashed of the puller's own changes.
+type Autostash struct {
+	Sha string
+
+	// Files are the paths the stash changed, and Conflicts those whose
+	// changes collided with the pulled work. A stash that conflicted is kept
+	// in the stash list.
+	Files     []string
+	Conflicts []string
+}
+
+// AutostashOf is the autostash a pull recorded, nil when it had nothing to
+// stash.
+func AutostashOf(state *saga.State) (*Autostash, error) {
+	sha, ok := lookup(state, autostashCommit)
+	if !ok {
+		return nil, nil
+	}
+
+	files, err := git.StashFiles(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	autostash := &Autostash{Sha: sha, Files: files}
+	if conflicts, ok := lookup(state, autostashConflicts); ok {
+		autostash.Conflicts = strings.Split(conflicts, "\n")
+	}
+
+	return autostash, nil
+}
+
+// autostashStep stashes the puller's changes, untracked files included,
+// and puts them back when undone.
+func autostashStep(verbose bool) saga.Step {
+	push := gitCommand{"stash", "push", "--include-untracked", "--message", autostashMessage}
+
+	return ensures(saga.Step{
+		Name: "git stash local changes",
+		Run: func(ctx context.Context) error {
+			changes, err := git.Changes()
+			if err != nil || len(changes) == 0 {
+				return err
+			}
+
+			if err := push.run(ctx, verbose); err != nil {
+				return err
+			}
+
+			return record(ctx, autostashCommit, "refs/stash")
+		},
+		Undo: func(ctx context.Context) error {
+			return withAutostash(ctx, func(sha string) error {
+				ref, err := git.StashRef(sha)
+				if err != nil {
+					return err
+				}
+
+				return gitCommand{"stash", "pop", "--index", "--quiet", ref}.run(ctx, verbose)
+			})
+		},
+		Commands:     []string{push.String()},
+		UndoCommands: []string{fmt.Sprintf("git stash pop --index --quiet <%s>", autostashCommit)},
+	}, cleanWorkingTree())
+}
+
+// applyAutostashStep merges the stashed changes into the pulled work while
+// it is still committed, so that they can conflict like any merge. Conflicts
+// are recorded rather than failing the pull.
+func applyAutostashStep(verbose bool) saga.Step {
+	return saga.Step{
+		Name: "git stash apply local changes",
+		Run: func(ctx context.Context) error {
+			return withAutostash(ctx, func(sha string) error {
+				err := gitCommand{"stash", "apply", "--quiet", sha}.run(ctx, verbose)
+				if err == nil {
+					return nil
+				}
+
+				files, conflictsErr := git.ConflictedFiles()
+				if conflictsErr != nil || len(files) == 0 {
+					return err
+				}
+
+				saga.StateFrom(ctx).Set(autostashConflicts, strings.Join(files, "\n"))
+
+				return nil
+			})
+		},
+		Undo: func(ctx context.Context) error {
+			return withAutostash(ctx, func(sha string) error {
+				if err := (gitCommand{"reset", "--hard", "--quiet"}).run(ctx, verbose); err != nil {
+					return err
+				}
+
+				untracked, err := git.StashUntrackedFiles(sha)
+				if err != nil || len(untracked) == 0 {
+					return err
+				}
+
+				return append(gitCommand{"clean", "--force", "--quiet", "--"}, untracked...).run(ctx, verbose)
+			})
+		},
+		Commands:     []string{fmt.Sprintf("git stash apply --quiet <%s>", autostashCommit)},
+		UndoCommands: []string{"git reset --hard --quiet", fmt.Sprintf("git clean --force --quiet -- <untracked files of %s>", autostashCommit)},
+	}
+}
+
+// dropAutostashStep takes a cleanly applied autostash off the stash list.
+func dropAutostashStep(verbose bool) saga.Step {
+	return saga.Step{
+		Name: "git stash drop local changes",
+		Run: func(ctx context.Context) error {
+			return withAppliedAutostash(ctx, func(sha string) error {
+				ref, err := git.StashRef(sha)
+				if err != nil {
+					return err
+				}
+
+				return gitCommand{"stash", "drop", "--quiet", ref}.run(ctx, verbose)
+			})
+		},
+		Undo: func(ctx context.Context) error {
+			return withAppliedAutostash(ctx, func(sha string) error {
+				return gitCommand{"stash", "store", "-m", autostashMessage, sha}.run(ctx, verbose)
+			})
+		},
+		Commands:     []string{fmt.Sprintf("git stash drop --quiet <%s>", autostashCommit)},
+		UndoCommands: []string{fmt.Sprintf("git stash store -m %q <%s>", autostashMessage, autostashCommit)},
+	}
+}
+
+// withAutostash calls fn with the autostash, if anything was stashed.
+func withAutostash(ctx context.Context, fn func(sha string) error) error {
+	sha, ok := lookup(saga.StateFrom(ctx), autostashCommit)
+	if !ok {
+		return nil
+	}
+
+	return fn(sha)
+}
+
+// withAppliedAutostash calls fn with the autostash, if it applied without
+// conflicts.
+func withAppliedAutostash(ctx context.Context, fn func(sha string) error) error {
+	if _, conflicted := lookup(saga.StateFrom(ctx), autostashConflicts); conflicted {
+		return nil
+	}
+
+	return withAutostash(ctx, fn)
+}
@@ -0,0 +1,77 @@
+package portal
+
+import (
+	"fmt"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+)
+
+// PullCheck predicts how the portal work would apply onto a base, from a
+// merge done in memory.
+type PullCheck struct {
+	// Target is what the portal work was merged onto, and Behind how many
+	// commits it has that the pusher's base doesn't.
+	Target string
+	Behind int
+
+	// Files are the paths the portal work changes, Conflicts those that
+	// collide with the target.
+	Files     []string
+	Conflicts []string
+	Messages  []string
+}
+
+func (c PullCheck) Clean() bool {
+	return len(c.Conflicts) == 0
+}
+
+// CheckPull merges the portal commits, portalWork, onto upstream, where
+// origin's working branch is, or onto the branch given, as a rebased pull
+// would apply them. Nothing the puller has is touched.
+func CheckPull(config Meta, portalWork string, upstream string, onto string) (*PullCheck, error) {
+	name, target, err := checkTarget(config.Meta.WorkingBranch, upstream, onto)
+	if err != nil {
+		return nil, err
+	}
+
+	base := config.Meta.Sha
+
+	behind, err := git.CommitsBetween(base, target)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := git.ChangedFiles(base, portalWork)
+	if err != nil {
+		return nil, err
+	}
+
+	merge, err := git.MergeTrees(base, target, portalWork)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PullCheck{
+		Target:    name,
+		Behind:    behind,
+		Files:     files,
+		Conflicts: merge.Conflicts,
+		Messages:  merge.Messages,
+	}, nil
+}
+
+// checkTarget is the name and revision of where a pull would apply the
+// portal work: origin's working branch, or the onto branch, HEAD when it is
+// yet to be created.
+func checkTarget(workingBranch string, upstream string, onto string) (string, string, error) {
+	if onto == "" {
+		return fmt.Sprintf("origin/%s", workingBranch), upstream, nil
+	}
+
+	exists, err := git.LocalBranchExists(onto)
+	if err != nil || !exists {
+		return "HEAD", "HEAD", err
+	}
+
+	return onto, onto, nil
+}
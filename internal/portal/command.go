@@ -0,0 +1,191 @@
+package portal
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/logger"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+// gitCommand is the argv of a git invocation, without the leading "git".
+type gitCommand []string
+
+func (c gitCommand) String() string {
+	args := []string{"git"}
+	for _, arg := range c {
+		args = append(args, quote(arg))
+	}
+
+	return strings.Join(args, " ")
+}
+
+func (c gitCommand) run(ctx context.Context, verbose bool) error {
+	// a compensation's backup failing shouldn't fail the compensation
+	if dir, destructive := c.destructive(); destructive && !saga.Compensating(ctx) {
+		if err := backUp(dir, "before "+c.String()); err != nil {
+			return fmt.Errorf("could not back up the working tree before %s: %w", c, err)
+		}
+	}
+
+	_, err := git.Run(ctx, git.Command{Args: c, Verbose: verbose})
+
+	return err
+}
+
+// destructive is whether the command throws away what is in a working tree,
+// a hard reset or a clean, and the directory it runs in when -C says.
+func (c gitCommand) destructive() (dir string, destructive bool) {
+	args := []string(c)
+	for len(args) > 1 && (args[0] == "-C" || args[0] == "-c") {
+		if args[0] == "-C" && filepath.IsAbs(args[1]) {
+			dir = args[1]
+		} else if args[0] == "-C" {
+			dir = filepath.Join(dir, args[1])
+		}
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return dir, false
+	}
+
+	switch args[0] {
+	case "clean":
+		return dir, true
+	case "reset":
+		for _, arg := range args[1:] {
+			if arg == "--hard" {
+				return dir, true
+			}
+		}
+	}
+
+	return dir, false
+}
+
+// BackUpWorktree snapshots the working tree, keeping as many backups as
+// portal.backupRetention allows.
+func BackUpWorktree(reason string) error {
+	return backUp("", reason)
+}
+
+// backUp snapshots the working tree of the repository in dir, a submodule's
+// backups being kept in the submodule.
+func backUp(dir string, reason string) error {
+	keep, err := git.BackupRetention()
+	if err != nil || keep == 0 {
+		return err
+	}
+
+	if _, err := git.CreateBackup(dir, reason); err != nil {
+		return err
+	}
+
+	if err := git.PruneBackups(dir, keep); err != nil {
+		logger.LogError.Println(err)
+	}
+
+	return nil
+}
+
+func commandStep(verbose bool, name string, run []gitCommand, undo []gitCommand) saga.Step {
+	step := saga.Step{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			return runAll(ctx, run, verbose)
+		},
+		Commands:     describe(run),
+		UndoCommands: describe(undo),
+	}
+
+	if len(undo) > 0 {
+		step.Undo = func(ctx context.Context) error {
+			return runAll(ctx, undo, verbose)
+		}
+	}
+
+	return step
+}
+
+// NetworkRetry is how git commands that talk to the remote are retried.
+var NetworkRetry = saga.Retry{Attempts: 3, Backoff: 2 * time.Second, Retryable: git.Transient}
+
+// NetworkTimeout is how long a step that talks to the remote may take, so an
+// unreachable remote doesn't hang portal.
+var NetworkTimeout = 5 * time.Minute
+
+func networkStep(step saga.Step) saga.Step {
+	step.Retry = NetworkRetry
+	step.Timeout = NetworkTimeout
+
+	return step
+}
+
+// requires adds conditions that must hold for the step to run.
+func requires(step saga.Step, conditions ...saga.Condition) saga.Step {
+	step.Pre = append(step.Pre, conditions...)
+
+	return step
+}
+
+// ensures adds conditions that must hold once the step has run. A step
+// that ran but broke them is aborted, so one without an Abort of its own is
+// aborted with its Undo.
+func ensures(step saga.Step, conditions ...saga.Condition) saga.Step {
+	step.Post = append(step.Post, conditions...)
+	if step.Abort == nil {
+		step.Abort = step.Undo
+	}
+
+	return step
+}
+
+// deleteRemoteBranch deletes branch from origin only while it is still at
+// sha, so a branch someone else pushed since is left alone.
+func deleteRemoteBranch(branch string, sha string) gitCommand {
+	return gitCommand{"push", "origin", "--delete", fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branch, sha), branch, "--progress"}
+}
+
+// abortPush deletes a branch an interrupted push may have created anyway, the
+// remote can take the push before git is killed.
+func abortPush(branch string, verbose bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		pushed, err := git.RemoteBranches(branch)
+		if err != nil || len(pushed) == 0 {
+			return err
+		}
+
+		return gitCommand{"push", "origin", "--delete", branch, "--progress"}.run(ctx, verbose)
+	}
+}
+
+func runAll(ctx context.Context, commands []gitCommand, verbose bool) (err error) {
+	for _, command := range commands {
+		if err = command.run(ctx, verbose); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func describe(commands []gitCommand) (descriptions []string) {
+	for _, command := range commands {
+		descriptions = append(descriptions, command.String())
+	}
+
+	return
+}
+
+func quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+		return arg
+	}
+
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
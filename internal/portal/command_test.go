@@ -0,0 +1,43 @@
+package portal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestructiveCommands(t *testing.T) {
+	cases := []struct {
+		command     gitCommand
+		dir         string
+		destructive bool
+	}{
+		{gitCommand{"reset", "--hard", "origin/main"}, "", true},
+		{gitCommand{"reset", "--quiet", "HEAD^"}, "", false},
+		{gitCommand{"clean", "-fd"}, "", true},
+		{gitCommand{"-C", "/repo/library", "reset", "--hard", "--quiet"}, "/repo/library", true},
+		{gitCommand{"-C", "/repo", "-C", "library", "clean", "-fd"}, "/repo/library", true},
+		{gitCommand{"-c", "core.hooksPath=/dev/null", "reset", "--hard"}, "", true},
+		{gitCommand{"-C", "/repo/library", "checkout", "--force", "-"}, "/repo/library", false},
+	}
+
+	for _, c := range cases {
+		dir, destructive := c.command.destructive()
+		assert.Equal(t, c.destructive, destructive, c.command.String())
+		assert.Equal(t, c.dir, dir, c.command.String())
+	}
+}
+
+func TestCommitCommands(t *testing.T) {
+	assert.Equal(t, []string{
+		"git write-tree",
+		"git -c core.hooksPath=/dev/null commit --allow-empty -m <portal meta>",
+	}, Hooks{Policy: BypassHooks}.commitCommands())
+
+	assert.Equal(t, []string{
+		"<pre-commit hook>",
+		"git write-tree",
+		"<commit-msg hook> <portal meta>",
+		"git commit --allow-empty --no-verify -m <portal meta>",
+	}, Hooks{Policy: RunHooks}.commitCommands())
+}
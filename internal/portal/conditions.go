@@ -0,0 +1,258 @@
+package portal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+func onBranch(branch string) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("HEAD on %s", branch),
+		Check: func(ctx context.Context) error {
+			current, err := git.GetCurrentBranch()
+			if err != nil {
+				return err
+			}
+
+			if current != branch {
+				return fmt.Errorf("HEAD is on %s", current)
+			}
+
+			return nil
+		},
+	}
+}
+
+func headAt(ref string) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("HEAD at %s", ref),
+		Check: func(ctx context.Context) error {
+			expected, err := git.RevParse(ref)
+			if err != nil {
+				return err
+			}
+
+			head, err := git.HeadSha()
+			if err != nil {
+				return err
+			}
+
+			if head != expected {
+				return fmt.Errorf("HEAD is at %s", head)
+			}
+
+			return nil
+		},
+	}
+}
+
+// headAtRecorded checks HEAD is at the sha a step recorded.
+func headAtRecorded(key saga.Key) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("HEAD at %s", key),
+		Check: func(ctx context.Context) error {
+			expected, err := saga.StateFrom(ctx).Get(key)
+			if err != nil {
+				return err
+			}
+
+			head, err := git.HeadSha()
+			if err != nil {
+				return err
+			}
+
+			if head != expected {
+				return fmt.Errorf("HEAD is at %s instead of %s", head, expected)
+			}
+
+			return nil
+		},
+	}
+}
+
+// headAtParentOf checks HEAD is at the parent of the sha a step recorded.
+func headAtParentOf(key saga.Key) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("HEAD at <%s>^", key),
+		Check: func(ctx context.Context) error {
+			sha, err := saga.StateFrom(ctx).Get(key)
+			if err != nil {
+				return err
+			}
+
+			expected, err := git.RevParse(sha + "^")
+			if err != nil {
+				return err
+			}
+
+			head, err := git.HeadSha()
+			if err != nil {
+				return err
+			}
+
+			if head != expected {
+				return fmt.Errorf("HEAD is at %s instead of %s", head, expected)
+			}
+
+			return nil
+		},
+	}
+}
+
+func headIsPortalCommit() saga.Condition {
+	return saga.Condition{
+		Name: "HEAD at the portal commit",
+		Check: func(ctx context.Context) error {
+			message, err := git.CommitMessage("HEAD")
+			if err != nil {
+				return err
+			}
+
+			if !isPortalCommit(message) {
+				return errors.New("HEAD is not a portal commit")
+			}
+
+			return nil
+		},
+	}
+}
+
+func noRebaseInProgress() saga.Condition {
+	return saga.Condition{
+		Name: "no rebase in progress",
+		Check: func(ctx context.Context) error {
+			if git.RebaseInProgress() {
+				return errors.New("a rebase is in progress")
+			}
+
+			return nil
+		},
+	}
+}
+
+func localBranchExists(branch string, exists bool) saga.Condition {
+	name := fmt.Sprintf("local branch %s", branch)
+	if !exists {
+		name = fmt.Sprintf("no local branch %s", branch)
+	}
+
+	return saga.Condition{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			localBranch, err := git.LocalBranchExists(branch)
+			if err != nil {
+				return err
+			}
+
+			if localBranch != exists {
+				return fmt.Errorf("local branch %s exists: %t", branch, !exists)
+			}
+
+			return nil
+		},
+	}
+}
+
+// remoteBranchAt checks origin's branch is at the sha a step recorded.
+func remoteBranchAt(branch string, key saga.Key) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("origin/%s at %s", branch, key),
+		Check: func(ctx context.Context) error {
+			expected, err := saga.StateFrom(ctx).Get(key)
+			if err != nil {
+				return err
+			}
+
+			remote, err := git.RemoteHeadSha(branch)
+			if err != nil {
+				return err
+			}
+
+			if remote != expected {
+				return fmt.Errorf("origin/%s is at %q instead of %s", branch, remote, expected)
+			}
+
+			return nil
+		},
+	}
+}
+
+func remoteBranchGone(branch string) saga.Condition {
+	return saga.Condition{
+		Name: fmt.Sprintf("no origin/%s", branch),
+		Check: func(ctx context.Context) error {
+			remote, err := git.RemoteHeadSha(branch)
+			if err != nil {
+				return err
+			}
+
+			if remote != "" {
+				return fmt.Errorf("origin/%s still exists at %s", branch, remote)
+			}
+
+			return nil
+		},
+	}
+}
+
+func everythingStaged() saga.Condition {
+	return saga.Condition{
+		Name: "every change staged",
+		Check: func(ctx context.Context) error {
+			return changesError("not staged", git.UnstagedChanges)
+		},
+	}
+}
+
+func cleanWorkingTree() saga.Condition {
+	return saga.Condition{
+		Name: "a clean working tree",
+		Check: func(ctx context.Context) error {
+			return changesError("changed", git.Changes)
+		},
+	}
+}
+
+func tracked(pathspecs []string, isTracked bool) saga.Condition {
+	name := "ignored files tracked"
+	if !isTracked {
+		name = "ignored files untracked"
+	}
+
+	return saga.Condition{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			files, err := git.TrackedFiles(pathspecs)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case isTracked && len(files) < len(pathspecs):
+				return fmt.Errorf("only %s are tracked", strings.Join(files, ", "))
+			case !isTracked && len(files) > 0:
+				return fmt.Errorf("%s are still tracked", strings.Join(files, ", "))
+			}
+
+			return nil
+		},
+	}
+}
+
+func changesError(state string, changes func() ([]string, error)) error {
+	files, err := changes()
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		return fmt.Errorf("%s: %s", state, strings.Join(files, ", "))
+	}
+
+	return nil
+}
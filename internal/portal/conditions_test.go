@@ -0,0 +1,61 @@
+package portal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+func fakeGit(t *testing.T) *git.FakeRunner {
+	fake := &git.FakeRunner{}
+	previous := git.SetRunner(fake)
+	check(git.UseBackend(git.ExecBackend))
+	t.Cleanup(func() {
+		git.SetRunner(previous)
+		check(git.UseBackend(backendUnderTest))
+	})
+
+	return fake
+}
+
+func TestRemoteBranchAtRecordedSha(t *testing.T) {
+	fake := fakeGit(t)
+	fake.On("ls-remote", "--heads", "origin", "refs/heads/pa-ir").Returns("abc\trefs/heads/pa-ir\n")
+
+	pushed := func(sha string) error {
+		s := saga.New([]saga.Step{{
+			Name: "push",
+			Run: func(ctx context.Context) (err error) {
+				saga.StateFrom(ctx).Set(portalCommit, sha)
+				return
+			},
+			Post: []saga.Condition{remoteBranchAt("pa-ir", portalCommit)},
+		}})
+
+		return s.Run(context.Background())
+	}
+
+	assert.NoError(t, pushed("abc"))
+	assert.Contains(t, pushed("def").Error(), `origin/pa-ir is at "abc" instead of def`)
+}
+
+func TestLocalBranchExistsFailsWithGit(t *testing.T) {
+	fake := fakeGit(t)
+	fake.On("branch", "--list").Fails(128, "fatal: not a git repository\n")
+
+	assert.Error(t, localBranchExists("pa-ir", false).Check(context.Background()))
+}
+
+func TestPushStepCommands(t *testing.T) {
+	fake := fakeGit(t)
+	fake.On("push").Returns("")
+
+	step := commandStep(false, "push", []gitCommand{{"push", "origin", "pair branch"}}, nil)
+
+	assert.NoError(t, step.Run(context.Background()))
+	assert.Equal(t, []string{"git push origin pair branch"}, fake.Ran())
+}
@@ -1,19 +1,53 @@
 package portal
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/ericTsiliacos/portal/internal/git"
 )
 
+// backendUnderTest is the git backend the tests currently run against.
+var backendUnderTest string
+
+// TestMain runs every test once per git backend.
+func TestMain(m *testing.M) {
+	for _, backend := range git.Backends {
+		backendUnderTest = backend
+		check(git.UseBackend(backend))
+
+		if code := m.Run(); code != 0 {
+			fmt.Printf("failed with the %s backend\n", backend)
+			os.Exit(code)
+		}
+	}
+
+	os.Exit(0)
+}
+
 func check(e error) {
 	if e != nil {
 		panic(e)
 	}
 }
 
+func pushConfig() Meta {
+	config := Meta{}
+	config.Meta.Version = "v1.0.0"
+	return config
+}
+
+func pullConfig(sha string) Meta {
+	config := Meta{}
+	config.Meta.Sha = sha
+	return config
+}
+
 func SetupBareGitRepository(t *testing.T, rootDirectory string) {
 	t.Helper()
 
@@ -42,10 +76,51 @@ func SetupBareGitRepository(t *testing.T, rootDirectory string) {
 	check(os.Chdir(rootDirectory))
 }
 
+func SetupSubmodule(t *testing.T, rootDirectory string, name string) {
+	t.Helper()
+
+	libraryPath := filepath.Join(rootDirectory, name)
+	check(os.Mkdir(libraryPath, 0755))
+	check(os.Chdir(libraryPath))
+
+	_, err := exec.Command("git", "init", "--bare").Output()
+	check(err)
+
+	check(os.Chdir(rootDirectory))
+
+	_, err = exec.Command("git", "clone", name, "setup-"+name).Output()
+	check(err)
+
+	check(os.Chdir(filepath.Join(rootDirectory, "setup-"+name)))
+	check(ioutil.WriteFile("lib.txt", []byte("library\n"), 0644))
+
+	_, err = exec.Command("git", "add", "lib.txt").Output()
+	check(err)
+
+	_, err = exec.Command("git", "commit", "--message", "library").Output()
+	check(err)
+
+	_, err = exec.Command("git", "push", "origin", "HEAD").Output()
+	check(err)
+
+	check(os.Chdir(filepath.Join(rootDirectory, "setup")))
+
+	_, err = exec.Command("git", "-c", "protocol.file.allow=always", "submodule", "add", "../"+name, name).Output()
+	check(err)
+
+	_, err = exec.Command("git", "commit", "--message", "add submodule").Output()
+	check(err)
+
+	_, err = exec.Command("git", "push", "origin", "HEAD").Output()
+	check(err)
+
+	check(os.Chdir(rootDirectory))
+}
+
 func CloneRepository(t *testing.T, rootDirectory string, name string) string {
 	t.Helper()
 
-	_, err := exec.Command("git", "clone", "project", name, "--progress").Output()
+	_, err := exec.Command("git", "-c", "protocol.file.allow=always", "clone", "--recurse-submodules", "project", name, "--progress").Output()
 	check(err)
 
 	clonePath := filepath.Join(rootDirectory, name)
@@ -71,6 +146,24 @@ func LocalBranchExists(t *testing.T, branch string) bool {
 	return len(localBranch) > 0
 }
 
+func HeadSha(t *testing.T) string {
+	t.Helper()
+
+	sha, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	check(err)
+
+	return strings.TrimSpace(string(sha))
+}
+
+func RevParse(t *testing.T, ref string) string {
+	t.Helper()
+
+	sha, err := exec.Command("git", "rev-parse", ref).Output()
+	check(err)
+
+	return strings.TrimSpace(string(sha))
+}
+
 func CleanIndex(t *testing.T) bool {
 	t.Helper()
 
@@ -80,3 +173,241 @@ func CleanIndex(t *testing.T) bool {
 	indexCount := strings.Count(string(index), "\n")
 	return !(indexCount > 0)
 }
+
+func TrackedFile(t *testing.T, file string) bool {
+	t.Helper()
+
+	trackedFile, err := exec.Command("git", "ls-files", file).Output()
+	check(err)
+
+	return len(trackedFile) > 0
+}
+
+func IgnoreFile(t *testing.T, file string) {
+	t.Helper()
+
+	infoPath := filepath.Join(".git", "info")
+	check(os.MkdirAll(infoPath, 0755))
+	check(ioutil.WriteFile(filepath.Join(infoPath, "exclude"), []byte(file+"\n"), 0644))
+}
+
+func InstallHook(t *testing.T, name string, script string) {
+	t.Helper()
+
+	hooksPath := filepath.Join(".git", "hooks")
+	check(os.MkdirAll(hooksPath, 0755))
+	check(ioutil.WriteFile(filepath.Join(hooksPath, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+}
+
+func StashList(t *testing.T) string {
+	t.Helper()
+
+	stashList, err := exec.Command("git", "stash", "list").Output()
+	check(err)
+
+	return string(stashList)
+}
+
+// fakeRepository is git scripted as a repository on main, which tracks
+// origin/main, following its branches, HEAD, index and origin through the
+// commands portal runs so that sagas run, conditions included, without git.
+// Commits are named rather than hashed, both main branches start at base.
+type fakeRepository struct {
+	*git.FakeRunner
+
+	branch   string
+	head     string
+	staged   bool
+	branches map[string]string
+	origin   map[string]string
+	parents  map[string]string
+	messages map[string]string
+}
+
+func fakeRepo(t *testing.T) *fakeRepository {
+	r := &fakeRepository{
+		FakeRunner: fakeGit(t),
+		branch:     "main",
+		head:       "base",
+		branches:   map[string]string{"main": "base"},
+		origin:     map[string]string{"main": "base"},
+		parents:    map[string]string{},
+		messages:   map[string]string{"base": "base\n"},
+	}
+
+	r.On().Returns("")
+	r.On("config", "--get", "portal.backupRetention").Returns("0\n")
+	r.On("rev-list", "--boundary").Returns("-base\n")
+	r.On("write-tree").Returns("tree\n")
+	r.On("rev-parse").Answers(r.revParse)
+	r.On("log").Answers(func(args []string) string { return r.messages[r.resolve(args[1])] })
+	r.On("add").Answers(func([]string) string { r.staged = true; return "" })
+	r.On("commit").Answers(r.commit)
+	r.On("-c", "core.hooksPath=/dev/null", "commit").Answers(r.commit)
+	r.On("reset").Answers(r.reset)
+	r.On("rebase").Answers(r.rebase)
+	r.On("checkout").Answers(r.checkout)
+	r.On("branch").Answers(r.branchCommand)
+	r.On("push").Answers(r.push)
+	r.On("ls-remote").Answers(r.lsRemote)
+
+	return r
+}
+
+// portal puts a portal commit for main, on top of parent, on origin's
+// portalBranch.
+func (r *fakeRepository) portal(portalBranch string, parent string) {
+	r.origin[portalBranch] = "portal"
+	r.parents["portal"] = parent
+	r.messages["portal"] = "Meta:\n  workingBranch: main\n"
+}
+
+func (r *fakeRepository) resolve(ref string) string {
+	switch {
+	case ref == "HEAD":
+		return r.head
+	case strings.HasSuffix(ref, "^"):
+		return r.parents[r.resolve(strings.TrimSuffix(ref, "^"))]
+	case strings.HasPrefix(ref, "refs/remotes/origin/"):
+		return r.origin[strings.TrimPrefix(ref, "refs/remotes/origin/")]
+	case strings.HasPrefix(ref, "origin/"):
+		return r.origin[strings.TrimPrefix(ref, "origin/")]
+	}
+
+	if sha, ok := r.branches[ref]; ok {
+		return sha
+	}
+
+	return ref
+}
+
+func (r *fakeRepository) moveHead(sha string) {
+	r.head = sha
+	if r.branch != "" {
+		r.branches[r.branch] = sha
+	}
+}
+
+func (r *fakeRepository) revParse(args []string) string {
+	last := args[len(args)-1]
+	switch {
+	case last == "@{u}":
+		return "origin/main\n"
+	case last == "HEAD" && args[1] == "--abbrev-ref":
+		return r.branch + "\n"
+	case args[1] == "--git-path" || args[1] == "--show-toplevel":
+		return ""
+	}
+
+	return r.resolve(last) + "\n"
+}
+
+func (r *fakeRepository) commit(args []string) string {
+	r.parents["portal"] = r.head
+	r.messages["portal"] = args[len(args)-1]
+	r.moveHead("portal")
+	r.staged = false
+
+	return ""
+}
+
+func (r *fakeRepository) reset(args []string) string {
+	r.staged = false
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			r.moveHead(r.resolve(arg))
+		}
+	}
+
+	return ""
+}
+
+func (r *fakeRepository) rebase(args []string) string {
+	if upstream := args[len(args)-1]; !strings.HasPrefix(upstream, "-") {
+		r.moveHead(r.resolve(upstream))
+	}
+
+	return ""
+}
+
+func (r *fakeRepository) checkout(args []string) string {
+	create := false
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "-b":
+			create = true
+		case strings.HasPrefix(arg, "-"):
+		case create:
+			r.branches[arg] = r.head
+			r.branch = arg
+			return ""
+		default:
+			r.branch = arg
+			r.head = r.branches[arg]
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func (r *fakeRepository) branchCommand(args []string) string {
+	names := []string{}
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			names = append(names, arg)
+		}
+	}
+
+	switch {
+	case args[1] == "--list":
+		if _, ok := r.branches[names[0]]; ok {
+			return "  " + names[0] + "\n"
+		}
+	case args[len(args)-2] == "-D":
+		delete(r.branches, names[0])
+	case len(names) == 2:
+		r.branches[names[0]] = r.resolve(names[1])
+	}
+
+	return ""
+}
+
+func (r *fakeRepository) push(args []string) string {
+	names := []string{}
+	deleting := false
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "--delete":
+			deleting = true
+		case !strings.HasPrefix(arg, "-"):
+			names = append(names, arg)
+		}
+	}
+
+	refspec := strings.SplitN(names[0], ":refs/heads/", 2)
+	switch {
+	case deleting:
+		delete(r.origin, names[0])
+	case len(refspec) == 2:
+		r.origin[refspec[1]] = r.resolve(refspec[0])
+	default:
+		r.origin[names[0]] = r.resolve(names[0])
+	}
+
+	return ""
+}
+
+func (r *fakeRepository) lsRemote(args []string) (heads string) {
+	for _, ref := range args[3:] {
+		branch := strings.TrimPrefix(ref, "refs/heads/")
+		if sha, ok := r.origin[branch]; ok {
+			heads += fmt.Sprintf("%s\t%s\n", sha, ref)
+		}
+	}
+
+	return
+}
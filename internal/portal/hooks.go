@@ -0,0 +1,129 @@
+package portal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/logger"
+)
+
+// HookPolicy is what the portal commit does with the repository's commit
+// hooks.
+type HookPolicy int
+
+const (
+	// RunHooks lets a failing pre-commit or commit-msg hook stop the push.
+	RunHooks HookPolicy = iota
+
+	// BypassHooks commits without running any hook.
+	BypassHooks
+
+	// ReportHooks runs the pre-commit and commit-msg hooks but commits even
+	// when they fail.
+	ReportHooks
+)
+
+var hookPolicies = map[string]HookPolicy{
+	"run":    RunHooks,
+	"bypass": BypassHooks,
+	"report": ReportHooks,
+}
+
+func ParseHookPolicy(name string) (HookPolicy, error) {
+	policy, ok := hookPolicies[name]
+	if !ok {
+		return RunHooks, fmt.Errorf("unknown hook policy %s, expected run, bypass or report", name)
+	}
+
+	return policy, nil
+}
+
+// Hooks is how the portal commit treats commit hooks.
+type Hooks struct {
+	Policy HookPolicy
+
+	// Reported receives the failures of hooks that only report.
+	Reported func(err error)
+}
+
+// commitArgs is the git commit, with git's own hook runs turned off since
+// portal runs them itself.
+func (h Hooks) commitArgs(message string) gitCommand {
+	if h.Policy == BypassHooks {
+		return gitCommand{"-c", "core.hooksPath=/dev/null", "commit", "--allow-empty", "-m", message}
+	}
+
+	return gitCommand{"commit", "--allow-empty", "--no-verify", "-m", message}
+}
+
+// commitCommands describes what making the portal commit runs.
+func (h Hooks) commitCommands() []string {
+	// the message is the last arg, shown as a placeholder rather than quoted
+	args := h.commitArgs("")
+	commit := args[:len(args)-1].String() + " <portal meta>"
+	if h.Policy == BypassHooks {
+		return []string{"git write-tree", commit}
+	}
+
+	return []string{"<pre-commit hook>", "git write-tree", "<commit-msg hook> <portal meta>", commit}
+}
+
+func (h Hooks) preCommit(ctx context.Context, verbose bool) error {
+	if h.Policy == BypassHooks {
+		return nil
+	}
+
+	return h.failed(git.RunHook(ctx, "pre-commit", verbose))
+}
+
+// commitMsg runs the commit-msg hook on message and returns the message the
+// hook left, it may edit it.
+func (h Hooks) commitMsg(ctx context.Context, message string, verbose bool) (string, error) {
+	if h.Policy == BypassHooks {
+		return message, nil
+	}
+
+	file, err := ioutil.TempFile("", "portal-commit-msg")
+	if err != nil {
+		return message, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(message)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return message, err
+	}
+
+	if err = h.failed(git.RunHook(ctx, "commit-msg", verbose, file.Name())); err != nil {
+		return message, err
+	}
+
+	edited, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return message, err
+	}
+
+	return string(edited), nil
+}
+
+// failed only lets a hook failure through when hooks stop the push.
+func (h Hooks) failed(err error) error {
+	var hookError *git.HookError
+	if h.Policy != ReportHooks || !errors.As(err, &hookError) {
+		return err
+	}
+
+	logger.LogInfo.Println(err, hookError.Output)
+	if h.Reported != nil {
+		h.Reported(hookError)
+	}
+
+	return nil
+}
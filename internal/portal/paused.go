@@ -0,0 +1,67 @@
+package portal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+const pausedPullFile = "pull.json"
+
+// PausedPull is what a pull stopped on conflicts needs to be built again,
+// so that a later run can resume or abort it.
+type PausedPull struct {
+	StartingBranch string          `json:"startingBranch"`
+	StartingSha    string          `json:"startingSha"`
+	PortalBranch   string          `json:"portalBranch"`
+	Config         Meta            `json:"config"`
+	Options        PullOptions     `json:"options"`
+	Checkpoint     saga.Checkpoint `json:"checkpoint"`
+}
+
+func (p PausedPull) Steps(verbose bool) ([]saga.Step, error) {
+	return PullSagaSteps(p.StartingBranch, p.PortalBranch, p.Config, p.Options, verbose)
+}
+
+func SavePausedPull(dir string, paused PausedPull) error {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(paused, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, pausedPullFile), data, 0660)
+}
+
+// LoadPausedPull is the pull paused in dir, nil when there isn't one.
+func LoadPausedPull(dir string) (*PausedPull, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, pausedPullFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paused := &PausedPull{}
+	if err := json.Unmarshal(data, paused); err != nil {
+		return nil, err
+	}
+
+	return paused, nil
+}
+
+func RemovePausedPull(dir string) error {
+	err := os.Remove(filepath.Join(dir, pausedPullFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -4,18 +4,25 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/ericTsiliacos/portal/internal/git"
 	"github.com/ericTsiliacos/portal/internal/portal/strategies"
 )
 
 type Meta struct {
 	Meta struct {
-		Version       string `yaml:"version"`
-		WorkingBranch string `yaml:"workingBranch"`
-		Sha           string `yaml:"sha"`
-		Message       string `yaml:"message"`
+		Version       string      `yaml:"version"`
+		WorkingBranch string      `yaml:"workingBranch"`
+		Sha           string      `yaml:"sha"`
+		Message       string      `yaml:"message"`
+		Tree          string      `yaml:"tree,omitempty"`
+		IgnoredFiles  []string    `yaml:"ignoredFiles,omitempty"`
+		Submodules    []Submodule `yaml:"submodules,omitempty"`
+		Stashes       []Stash     `yaml:"stashes,omitempty"`
+		Kept          bool        `yaml:"kept,omitempty"`
 	} `yaml:"Meta"`
 }
 
@@ -29,6 +36,18 @@ func GetConfiguration(yamlContent string) (*Meta, error) {
 	return c, err
 }
 
+func IncludeIgnoredPatterns(flag string) []string {
+	patterns := git.IncludeIgnoredPatterns()
+
+	for _, pattern := range strings.Split(flag, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
 func BranchNameStrategy(strategyName string) (string, error) {
 	strategies := []strategies.Strategy{
 		strategies.GitDuet{},
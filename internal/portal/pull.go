@@ -3,65 +3,346 @@ package portal
 import (
 	"context"
 	"fmt"
-	"os/exec"
+	"strings"
 
 	"github.com/ericTsiliacos/portal/internal/git"
 	"github.com/ericTsiliacos/portal/internal/saga"
-	"github.com/ericTsiliacos/portal/internal/shell"
 )
 
-func PullSagaSteps(ctx context.Context, startingBranch string, portalBranch string, pusherSha string, verbose bool) (steps []saga.Step, err error) {
-	remoteTrackingBranch, err := git.GetRemoteTrackingBranch()
+// PullOptions change how the portal work is applied.
+type PullOptions struct {
+	// Rebase applies the portal work on top of origin's working branch as it
+	// is now, instead of where the pusher left it, pausing on conflicts.
+	Rebase bool `json:"rebase,omitempty"`
+
+	// Onto is a branch to apply the portal work on instead of the pusher's
+	// working branch. It is created from HEAD if it doesn't exist.
+	Onto string `json:"onto,omitempty"`
+
+	// Autostash stashes the puller's own changes for the pull and applies
+	// them again on top of the pulled work.
+	Autostash bool `json:"autostash,omitempty"`
+}
+
+// Rebased is whether the portal work is applied somewhere other than where
+// the pusher left it.
+func (o PullOptions) Rebased() bool {
+	return o.Rebase || o.Onto != ""
+}
+
+// PullSagaSteps only looks at the repository as it runs, so that the steps
+// of a paused pull can be built again to resume it.
+func PullSagaSteps(startingBranch string, portalBranch string, config Meta, options PullOptions, verbose bool) (steps []saga.Step, err error) {
+	if options.Autostash {
+		steps = append(steps, autostashStep(verbose))
+	}
+
+	switch {
+	case options.Onto != "":
+		steps = append(steps, requires(checkoutOntoStep(startingBranch, options.Onto, verbose),
+			onBranch(startingBranch), noRebaseInProgress()))
+		steps = append(steps, ontoSteps(portalBranch, config.Meta.Sha, verbose)...)
+	case options.Rebase:
+		steps = append(steps, requires(rebaseWorkingBranchStep(startingBranch, verbose),
+			onBranch(startingBranch), noRebaseInProgress()))
+		steps = append(steps, ontoSteps(portalBranch, config.Meta.Sha, verbose)...)
+	default:
+		steps = append(steps, requires(rebaseWorkingBranchStep(startingBranch, verbose),
+			onBranch(startingBranch), noRebaseInProgress()))
+		steps = append(steps,
+			ensures(commandStep(verbose, "git reset to pusher sha",
+				[]gitCommand{{"reset", "--hard", config.Meta.Sha}},
+				nil),
+				headAt(config.Meta.Sha)),
+			recording(ensures(rebaseStep(verbose, "git rebase portal work in progress",
+				fmt.Sprintf("origin/%s", portalBranch),
+				nil),
+				headIsPortalCommit()),
+				portalCommit, "HEAD"))
+	}
+
+	if options.Autostash {
+		steps = append(steps, applyAutostashStep(verbose))
+	}
+
+	if len(config.Meta.IgnoredFiles) > 0 {
+		pathspecs := git.TopLevelPathspecs(config.Meta.IgnoredFiles)
+
+		steps = append(steps, ensures(commandStep(verbose, "git untrack ignored files",
+			[]gitCommand{append(gitCommand{"rm", "--cached", "--quiet", "--"}, pathspecs...)},
+			[]gitCommand{append(gitCommand{"add", "--force", "--"}, pathspecs...)}),
+			tracked(pathspecs, false)))
+	}
+
+	restoreSubmodules, err := restoreSubmoduleSteps(config.Meta.Submodules, verbose)
 	if err != nil {
 		return
 	}
 
-	currentBranch, err := git.GetCurrentBranch()
+	// the portal commit sits on the pusher's unpublished commits, if any,
+	// which stay as commits
+	steps = append(steps, ensures(saga.Step{
+		Name: "git reset commits",
+		Run: func(ctx context.Context) error {
+			return gitCommand{"reset", "HEAD^"}.run(ctx, verbose)
+		},
+		Undo: func(ctx context.Context) error {
+			return recorded(ctx, portalCommit, verbose, func(sha string) gitCommand {
+				return gitCommand{"reset", "--quiet", sha}
+			})
+		},
+		Commands:     []string{gitCommand{"reset", "HEAD^"}.String()},
+		UndoCommands: []string{fmt.Sprintf("git reset --quiet <%s>", portalCommit)},
+	}, headAtParentOf(portalCommit)))
+
+	if options.Autostash {
+		steps = append(steps, dropAutostashStep(verbose))
+	}
+
+	pullStashes, err := pullStashSteps(portalBranch, config.Meta.Stashes, verbose)
 	if err != nil {
 		return
 	}
 
-	startingSha, err := git.GetBoundarySha(remoteTrackingBranch, currentBranch)
-	if err != nil {
+	steps = append(steps, restoreSubmodules...)
+	steps = append(steps, pullStashes...)
+
+	return append(steps, deleteRemoteStep(verbose, "delete remote portal branch", portalBranch, remotePortalCommit)), nil
+}
+
+// rebaseWorkingBranchStep brings the working branch up to date with origin,
+// recording where it started so that undoing puts it back.
+func rebaseWorkingBranchStep(branch string, verbose bool) saga.Step {
+	undo := func(ctx context.Context) error {
+		return recorded(ctx, startingCommit, verbose, func(sha string) gitCommand {
+			return gitCommand{"reset", "--hard", sha}
+		})
+	}
+
+	return ensures(saga.Step{
+		Name: "git rebase against remote working branch",
+		Run: func(ctx context.Context) error {
+			remoteTrackingBranch, err := git.GetRemoteTrackingBranch()
+			if err != nil {
+				return err
+			}
+
+			sha, err := git.GetBoundarySha(remoteTrackingBranch, branch)
+			if err != nil {
+				return err
+			}
+			saga.StateFrom(ctx).Set(startingCommit, sha)
+
+			return gitCommand{"rebase", fmt.Sprintf("origin/%s", branch)}.run(ctx, verbose)
+		},
+		Undo: undo,
+		Abort: func(ctx context.Context) error {
+			if err := abortRebase(ctx, verbose); err != nil {
+				return err
+			}
+
+			return undo(ctx)
+		},
+		Commands:     []string{gitCommand{"rebase", fmt.Sprintf("origin/%s", branch)}.String()},
+		UndoCommands: []string{fmt.Sprintf("git reset --hard <%s>", startingCommit)},
+	}, noRebaseInProgress())
+}
+
+// Moves describes, from the values a pull recorded, how it moved the portal
+// work when it rebased it: which branch it went onto, and the old and new
+// sha of its base and of the portal commit.
+func Moves(state map[string]string) (moves []string) {
+	if branch, ok := state[string(ontoBranch)]; ok {
+		moves = append(moves, fmt.Sprintf("onto branch %s", branch))
+	}
+
+	if _, rebased := state[string(pusherBaseCommit)]; !rebased {
 		return
 	}
 
-	return []saga.Step{
-		{
-			Name: "git rebase against remote working branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "rebase", fmt.Sprintf("origin/%s", startingBranch)), verbose)
-			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "reset", "--hard", startingSha), verbose)
-			},
+	pairs := []struct {
+		name     string
+		from, to saga.Key
+	}{
+		{"base", pusherBaseCommit, baseCommit},
+		{"portal commit", remotePortalCommit, portalCommit},
+	}
+
+	for _, pair := range pairs {
+		from, to := state[string(pair.from)], state[string(pair.to)]
+		if from != "" && to != "" {
+			moves = append(moves, fmt.Sprintf("%s %s -> %s", pair.name, from, to))
+		}
+	}
+
+	return
+}
+
+// checkoutOntoStep moves to the branch the portal work goes onto, creating
+// it from HEAD when there is none.
+func checkoutOntoStep(startingBranch string, branch string, verbose bool) saga.Step {
+	return ensures(saga.Step{
+		Name: fmt.Sprintf("git checkout %s", branch),
+		Run: func(ctx context.Context) error {
+			exists, err := git.LocalBranchExists(branch)
+			if err != nil {
+				return err
+			}
+
+			state := saga.StateFrom(ctx)
+			state.Set(ontoBranch, branch)
+			if exists {
+				return gitCommand{"checkout", "--quiet", branch}.run(ctx, verbose)
+			}
+
+			state.Set(createdBranch, branch)
+
+			return gitCommand{"checkout", "--quiet", "-b", branch}.run(ctx, verbose)
 		},
-		{
-			Name: "git reset to pusher sha",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "reset", "--hard", pusherSha), verbose)
-			},
+		Undo: func(ctx context.Context) error {
+			if err := (gitCommand{"checkout", "--quiet", startingBranch}).run(ctx, verbose); err != nil {
+				return err
+			}
+
+			if _, created := lookup(saga.StateFrom(ctx), createdBranch); !created {
+				return nil
+			}
+
+			return gitCommand{"branch", "--quiet", "-D", branch}.run(ctx, verbose)
 		},
-		{
-			Name: "git rebase portal work in progress",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "rebase", fmt.Sprintf("origin/%s", portalBranch)), verbose)
-			},
+		Commands: []string{fmt.Sprintf("git checkout --quiet [-b] %s", branch)},
+		UndoCommands: []string{
+			gitCommand{"checkout", "--quiet", startingBranch}.String(),
+			fmt.Sprintf("git branch --quiet -D %s (if created)", branch),
 		},
-		{
-			Name: "git reset commits",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "reset", "HEAD^"), verbose)
-			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "add", "--all"), verbose)
-			},
+	}, onBranch(branch))
+}
+
+// ontoSteps replay the portal commit from the pusher's sha onto where HEAD
+// is now, recording both bases. Conflicts pause the pull until they are
+// resolved.
+func ontoSteps(portalBranch string, pusherSha string, verbose bool) []saga.Step {
+	reset := ensures(saga.Step{
+		Name: "git reset to portal work in progress",
+		Run: func(ctx context.Context) error {
+			if err := record(ctx, baseCommit, "HEAD"); err != nil {
+				return err
+			}
+			saga.StateFrom(ctx).Set(pusherBaseCommit, pusherSha)
+
+			return gitCommand{"reset", "--hard", fmt.Sprintf("origin/%s", portalBranch)}.run(ctx, verbose)
+		},
+		Undo: func(ctx context.Context) error {
+			return recorded(ctx, baseCommit, verbose, func(sha string) gitCommand {
+				return gitCommand{"reset", "--hard", sha}
+			})
+		},
+		Commands:     []string{gitCommand{"reset", "--hard", fmt.Sprintf("origin/%s", portalBranch)}.String()},
+		UndoCommands: []string{fmt.Sprintf("git reset --hard <%s>", baseCommit)},
+	}, headIsPortalCommit())
+
+	rebase := func(ctx context.Context) error {
+		return recorded(ctx, baseCommit, verbose, func(sha string) gitCommand {
+			return gitCommand{"rebase", "--onto", sha, pusherSha}
+		})
+	}
+
+	onto := recording(ensures(saga.Step{
+		Name: "git rebase portal work in progress onto working branch",
+		Run: func(ctx context.Context) error {
+			return pauseOnConflicts(rebase(ctx))
+		},
+		Resume: func(ctx context.Context) error {
+			if !git.RebaseInProgress() {
+				return nil
+			}
+
+			_, err := git.Run(ctx, git.Command{
+				Args:    []string{"rebase", "--continue"},
+				Env:     []string{"GIT_EDITOR=true"},
+				Verbose: verbose,
+			})
+
+			return pauseOnConflicts(err)
+		},
+		Abort: func(ctx context.Context) error {
+			return abortRebase(ctx, verbose)
+		},
+		Commands: []string{fmt.Sprintf("git rebase --onto <%s> %s", baseCommit, pusherSha)},
+	}, headIsPortalCommit(), noRebaseInProgress()), portalCommit, "HEAD")
+
+	return []saga.Step{reset, onto}
+}
+
+// ConflictError is a rebase stopped on files both sides changed.
+type ConflictError struct {
+	Files []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicts in %s", strings.Join(e.Files, ", "))
+}
+
+// pauseOnConflicts pauses the run when err is a rebase stopped on conflicts,
+// leaving the rebase in progress to be resolved.
+func pauseOnConflicts(err error) error {
+	if err == nil || !git.RebaseInProgress() {
+		return err
+	}
+
+	files, conflictsErr := git.ConflictedFiles()
+	if conflictsErr != nil || len(files) == 0 {
+		return err
+	}
+
+	return saga.Pause(&ConflictError{Files: files})
+}
+
+func abortRebase(ctx context.Context, verbose bool) error {
+	if !git.RebaseInProgress() {
+		return nil
+	}
+
+	return gitCommand{"rebase", "--abort"}.run(ctx, verbose)
+}
+
+// rebaseStep aborts a rebase that was interrupted halfway before undoing it,
+// a killed rebase would otherwise stay in progress.
+func rebaseStep(verbose bool, name string, upstream string, undo []gitCommand) saga.Step {
+	step := ensures(commandStep(verbose, name, []gitCommand{{"rebase", upstream}}, undo),
+		noRebaseInProgress())
+
+	step.Abort = func(ctx context.Context) error {
+		if err := abortRebase(ctx, verbose); err != nil {
+			return err
+		}
+
+		return runAll(ctx, undo, verbose)
+	}
+
+	return step
+}
+
+// deleteRemoteStep deletes a portal branch from origin, recording where it
+// was under key so that undoing pushes it back.
+func deleteRemoteStep(verbose bool, name string, branch string, key saga.Key) saga.Step {
+	return networkStep(ensures(saga.Step{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			if err := record(ctx, key, fmt.Sprintf("refs/remotes/origin/%s", branch)); err != nil {
+				return err
+			}
+
+			return recorded(ctx, key, verbose, func(sha string) gitCommand {
+				return deleteRemoteBranch(branch, sha)
+			})
 		},
-		{
-			Name: "delete remote portal branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "push", "origin", "--delete", portalBranch, "--progress"), verbose)
-			},
+		Undo: func(ctx context.Context) error {
+			return recorded(ctx, key, verbose, func(sha string) gitCommand {
+				return gitCommand{"push", "origin", fmt.Sprintf("%s:refs/heads/%s", sha, branch), "--progress"}
+			})
 		},
-	}, nil
+		Commands:     []string{deleteRemoteBranch(branch, fmt.Sprintf("<%s>", key)).String()},
+		UndoCommands: []string{fmt.Sprintf("git push origin <%s>:refs/heads/%s --progress", key, branch)},
+	}, remoteBranchGone(branch)))
 }
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,61 +21,211 @@ func TestPortalPullSaga(t *testing.T) {
 	fileName := "foo"
 
 	currentBranch, sha := push(t, portalBranch, fileName)
-	pullSteps, err := PullSagaSteps(context.TODO(), currentBranch, portalBranch, sha, false)
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{}, false)
 	if err != nil {
 		t.FailNow()
 	}
 	pullSaga := saga.New(pullSteps)
-	errors := pullSaga.Run()
+	err = pullSaga.Run(context.TODO())
 
-	assert.Empty(t, errors)
+	assert.NoError(t, err)
 	assert.FileExists(t, fileName)
 	assert.False(t, RemoteBranchExists(t, portalBranch))
 	assert.False(t, LocalBranchExists(t, portalBranch))
 	assert.False(t, CleanIndex(t))
 }
 
-func TestPortalPullSagaWithFailures(t *testing.T) {
+func TestPortalPullSagaVerification(t *testing.T) {
 	portalBranch := "pa-ir-portal"
 	fileName := "foo"
 
-	currentBranch, sha := push(t, portalBranch, fileName)
+	currentBranch, _ := push(t, portalBranch, fileName)
+
+	metaFileContents, err := git.ShowCommitMessage(portalBranch)
+	check(err)
+	config, err := GetConfiguration(metaFileContents)
+	check(err)
+	assert.NotEmpty(t, config.Meta.Tree)
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, *config, PullOptions{}, false)
+	if err != nil {
+		t.FailNow()
+	}
+	pullSaga := saga.New(pullSteps)
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+
+	verification, err := Verify(*config)
+	check(err)
+	assert.True(t, verification.Matches())
+
+	check(ioutil.WriteFile("bar", []byte("bar\n"), 0644))
+
+	verification, err = Verify(*config)
+	check(err)
+	assert.False(t, verification.Matches())
+	assert.Equal(t, []string{"A\tbar"}, verification.Tree)
+
+	check(os.Remove("bar"))
+	assert.NoError(t, pullSaga.Undo(context.TODO()))
+	assert.NoFileExists(t, fileName)
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+	assert.True(t, CleanIndex(t))
+}
+
+func TestPortalPullSagaVerificationWithStagedFiles(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	rootDirectory := t.TempDir()
+
+	SetupBareGitRepository(t, rootDirectory)
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+
+	check(os.Chdir(rootDirectory))
+	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
+	check(ioutil.WriteFile("staged.txt", []byte("staged\n"), 0644))
+	_, err := exec.Command("git", "add", "staged.txt").Output()
+	check(err)
+	check(ioutil.WriteFile("unstaged.txt", []byte("unstaged\n"), 0644))
+
+	pushSteps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+
+	check(os.Chdir(clone1Path))
+	check(git.Fetch())
+
+	metaFileContents, err := git.ShowCommitMessage(portalBranch)
+	check(err)
+	config, err := GetConfiguration(metaFileContents)
+	check(err)
+
+	currentBranch, err := git.GetCurrentBranch()
+	check(err)
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, *config, PullOptions{}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+
+	verification, err := Verify(*config)
+	assert.NoError(t, err)
+	assert.True(t, verification.Matches())
+	assert.Empty(t, verification.Tree)
+}
+
+func TestPortalPullSagaWithIgnoredFiles(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := ".env"
+
+	rootDirectory := t.TempDir()
+
+	SetupBareGitRepository(t, rootDirectory)
+
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+	IgnoreFile(t, fileName)
+
+	check(os.Chdir(rootDirectory))
+	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
+	IgnoreFile(t, fileName)
+	check(ioutil.WriteFile(fileName, []byte("SECRET=portal\n"), 0644))
 
-	pullSteps, err := PullSagaSteps(context.TODO(), currentBranch, portalBranch, sha, false)
+	ignoredFiles, err := git.IgnoredFiles([]string{fileName})
 	if err != nil {
 		t.FailNow()
 	}
+	assert.Equal(t, []string{fileName}, ignoredFiles)
+
+	config := pushConfig()
+	config.Meta.IgnoredFiles = ignoredFiles
+	pushSteps, err := PushSagaSteps(portalBranch, config, Hooks{}, false)
+	if err != nil {
+		t.FailNow()
+	}
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+	assert.NoFileExists(t, fileName)
+
+	remoteTrackingBranch, _ := git.GetRemoteTrackingBranch()
+	currentBranch, _ := git.GetCurrentBranch()
+	sha, _ := git.GetBoundarySha(remoteTrackingBranch, currentBranch)
+
+	check(os.Chdir(clone1Path))
+	git.Fetch()
 
-	for i := 1; i < len(pullSteps); i++ {
-		fmt.Printf("test run %d:", i)
-		fmt.Println()
-		fmt.Println("-----------------")
+	check(ioutil.WriteFile(fileName, []byte("SECRET=puller\n"), 0644))
+	overwritten, err := git.OverwrittenFiles("origin/"+portalBranch, ignoredFiles)
+	check(err)
+	assert.Equal(t, []string{fileName}, overwritten)
+
+	check(ioutil.WriteFile(fileName, []byte("SECRET=portal\n"), 0644))
+	overwritten, err = git.OverwrittenFiles("origin/"+portalBranch, ignoredFiles)
+	check(err)
+	assert.Empty(t, overwritten)
 
-		testPortalPullSagaFailure(t, portalBranch, i)
+	config = pullConfig(sha)
+	config.Meta.IgnoredFiles = ignoredFiles
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, config, PullOptions{}, false)
+	if err != nil {
+		t.FailNow()
 	}
+	pullSaga := saga.New(pullSteps)
+	err = pullSaga.Run(context.TODO())
+
+	assert.NoError(t, err)
+	assert.FileExists(t, fileName)
+	assert.False(t, TrackedFile(t, fileName))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+	assert.True(t, CleanIndex(t))
 }
 
-func testPortalPullSagaFailure(t *testing.T, portalBranch string, index int) {
+func TestPortalPullSagaWithDirtySubmodule(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	library := "library"
+
 	rootDirectory := t.TempDir()
 
 	SetupBareGitRepository(t, rootDirectory)
+	SetupSubmodule(t, rootDirectory, library)
 
 	clone1Path := CloneRepository(t, rootDirectory, "clone1")
 
 	check(os.Chdir(rootDirectory))
 	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
-	fileName := "foo"
-	fileHandle, err := os.Create(fileName)
+	check(ioutil.WriteFile(filepath.Join(library, "lib.txt"), []byte("changed\n"), 0644))
+	check(ioutil.WriteFile(filepath.Join(library, "staged.txt"), []byte("staged\n"), 0644))
+	_, err := exec.Command("git", "-C", library, "add", "staged.txt").Output()
 	check(err)
-	defer fileHandle.Close()
 
-	pushSteps, err := PushSagaSteps(context.TODO(), portalBranch, "v1.0.0", false, "")
+	objects := func() string {
+		count, err := exec.Command("git", "-C", library, "count-objects").Output()
+		check(err)
+		return string(count)
+	}
+	before := objects()
+	dirty, err := DirtySubmodules()
+	assert.NoError(t, err)
+	assert.Equal(t, []Submodule{{Path: library, Sha: submoduleHead(t, library)}}, dirty)
+	assert.Equal(t, before, objects())
+
+	submodules, err := SnapshotSubmodules()
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Len(t, submodules, 1)
+
+	config := pushConfig()
+	config.Meta.Submodules = submodules
+	pushSteps, err := PushSagaSteps(portalBranch, config, Hooks{}, false)
 	if err != nil {
 		t.FailNow()
 	}
 	pushSaga := saga.New(pushSteps)
-	errs := pushSaga.Run()
-	assert.Empty(t, errs)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+	assert.NoFileExists(t, filepath.Join(library, "staged.txt"))
+	assert.True(t, CleanIndex(t))
+
+	backups, err := exec.Command("git", "-C", library, "for-each-ref", "--format=%(contents:subject)", "refs/portal/backup").Output()
+	check(err)
+	assert.Contains(t, string(backups), "reset --hard")
 
 	remoteTrackingBranch, _ := git.GetRemoteTrackingBranch()
 	currentBranch, _ := git.GetCurrentBranch()
@@ -80,28 +233,681 @@ func testPortalPullSagaFailure(t *testing.T, portalBranch string, index int) {
 
 	check(os.Chdir(clone1Path))
 	git.Fetch()
-	pullSteps, _ := PullSagaSteps(context.TODO(), currentBranch, portalBranch, sha, false)
+
+	config = pullConfig(sha)
+	config.Meta.Submodules = submodules
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, config, PullOptions{}, false)
 	if err != nil {
 		t.FailNow()
 	}
-	pullSteps = pullSteps[0 : len(pullSteps)-index]
-	stepsWithError := append(pullSteps, saga.Step{
+	pullSaga := saga.New(pullSteps)
+	err = pullSaga.Run(context.TODO())
+
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(filepath.Join(library, "lib.txt"))
+	check(err)
+	assert.Equal(t, "changed\n", string(contents))
+
+	staged, err := exec.Command("git", "-C", library, "diff", "--cached", "--name-only").Output()
+	check(err)
+	assert.Equal(t, "staged.txt\n", string(staged))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullSagaWithMovedSubmodule(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	library := "library"
+
+	rootDirectory := t.TempDir()
+
+	SetupBareGitRepository(t, rootDirectory)
+	SetupSubmodule(t, rootDirectory, library)
+
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+	recorded := RevParse(t, "HEAD:"+library)
+
+	check(os.Chdir(rootDirectory))
+	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
+	check(ioutil.WriteFile(filepath.Join(library, "lib.txt"), []byte("moved\n"), 0644))
+	_, err := exec.Command("git", "-C", library, "commit", "--all", "--message", "moved").Output()
+	check(err)
+	moved := submoduleHead(t, library)
+
+	_, err = SnapshotSubmodules()
+	assert.Error(t, err)
+
+	_, err = exec.Command("git", "-C", library, "push", "origin", "HEAD:refs/heads/moved").Output()
+	check(err)
+
+	submodules, err := SnapshotSubmodules()
+	check(err)
+	assert.Equal(t, []Submodule{{Path: library, Sha: moved, Index: "", Worktree: ""}}, submodules)
+
+	config := pushConfig()
+	config.Meta.Submodules = submodules
+	pushSteps, err := PushSagaSteps(portalBranch, config, Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+	assert.Equal(t, recorded, submoduleHead(t, library))
+	assert.True(t, CleanIndex(t))
+
+	remoteTrackingBranch, _ := git.GetRemoteTrackingBranch()
+	currentBranch, _ := git.GetCurrentBranch()
+	sha, _ := git.GetBoundarySha(remoteTrackingBranch, currentBranch)
+
+	check(os.Chdir(clone1Path))
+	git.Fetch()
+
+	config = pullConfig(sha)
+	config.Meta.Submodules = submodules
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, config, PullOptions{}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.Equal(t, moved, submoduleHead(t, library))
+	assert.Equal(t, recorded, RevParse(t, "HEAD:"+library))
+	assert.False(t, CleanIndex(t))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullSagaWithStashes(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+
+	rootDirectory := t.TempDir()
+
+	SetupBareGitRepository(t, rootDirectory)
+
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+
+	check(os.Chdir(rootDirectory))
+	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
+	check(ioutil.WriteFile("experiment", []byte("experiment\n"), 0644))
+	_, err := exec.Command("git", "stash", "push", "--include-untracked", "--message", "experiment").Output()
+	check(err)
+	check(ioutil.WriteFile("foo", []byte("foo\n"), 0644))
+
+	stashes, err := StashEntries()
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Len(t, stashes, 1)
+
+	config := pushConfig()
+	config.Meta.Stashes = stashes
+	pushSteps, err := PushSagaSteps(portalBranch, config, Hooks{}, false)
+	if err != nil {
+		t.FailNow()
+	}
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+	assert.Empty(t, StashList(t))
+	assert.True(t, RemoteBranchExists(t, StashBranch(portalBranch)))
+
+	remoteTrackingBranch, _ := git.GetRemoteTrackingBranch()
+	currentBranch, _ := git.GetCurrentBranch()
+	sha, _ := git.GetBoundarySha(remoteTrackingBranch, currentBranch)
+
+	check(os.Chdir(clone1Path))
+	git.Fetch()
+
+	config = pullConfig(sha)
+	config.Meta.Stashes = stashes
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, config, PullOptions{}, false)
+	if err != nil {
+		t.FailNow()
+	}
+	pullSaga := saga.New(pullSteps)
+	err = pullSaga.Run(context.TODO())
+
+	assert.NoError(t, err)
+	assert.FileExists(t, "foo")
+	assert.Contains(t, StashList(t), "experiment")
+	assert.False(t, RemoteBranchExists(t, StashBranch(portalBranch)))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+
+	_, err = exec.Command("git", "stash", "pop").Output()
+	check(err)
+	assert.FileExists(t, "experiment")
+}
+
+func TestPortalPullSagaWithUnpublishedCommits(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushUnpublished(t, portalBranch, fileName)
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.FileExists(t, fileName)
+	assert.FileExists(t, "unpublished")
+	assert.False(t, TrackedFile(t, fileName))
+	assert.Equal(t, sha, RevParse(t, "HEAD^"))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullRebaseWithUnpublishedCommits(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushUnpublished(t, portalBranch, fileName)
+	base := commitUpstream(t, "bar", "puller\n")
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Rebase: true}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.FileExists(t, fileName)
+	assert.FileExists(t, "unpublished")
+	assert.FileExists(t, "bar")
+	assert.False(t, TrackedFile(t, fileName))
+	assert.Equal(t, base, RevParse(t, "HEAD^"))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullSagaAbortsAResetThatMissed(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushUnpublished(t, portalBranch, fileName)
+	startingSha := HeadSha(t)
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{}, false)
+	check(err)
+	for i, step := range pullSteps {
+		if step.Name == "git reset commits" {
+			pullSteps[i] = ensures(step, saga.Condition{
+				Name: "Boom!",
+				Check: func(context.Context) error {
+					return errors.New("uh oh!")
+				},
+			})
+		}
+	}
+	pullSaga := saga.New(pullSteps)
+
+	assert.Error(t, pullSaga.Run(context.TODO()))
+	assert.NoFileExists(t, fileName)
+	assert.NoFileExists(t, "unpublished")
+	assert.True(t, CleanIndex(t))
+	assert.Equal(t, startingSha, HeadSha(t))
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullSagaWithFailures(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+
+	fakeRepo(t).portal(portalBranch, "base")
+	pullSteps, err := PullSagaSteps("main", portalBranch, pullConfig("base"), PullOptions{}, false)
+	check(err)
+
+	for completed := 1; completed < len(pullSteps); completed++ {
+		testPortalPullSagaFailure(t, portalBranch, completed)
+	}
+}
+
+func TestPortalPullSagaUndoesInReverse(t *testing.T) {
+	repo := fakeRepo(t)
+	repo.portal("pa-ir-portal", "base")
+
+	pullSteps, err := PullSagaSteps("main", "pa-ir-portal", pullConfig("base"), PullOptions{}, false)
+	check(err)
+	failed := 0
+	pullSaga := saga.New(append(pullSteps, saga.Step{
 		Name: "Boom!",
-		Run: func() error {
+		Run: func(context.Context) error {
+			failed = len(repo.Ran())
 			return errors.New("uh oh!")
 		},
-	})
-	pullSaga := saga.New(stepsWithError)
-	errs = pullSaga.Run()
+	}))
+
+	assert.Error(t, pullSaga.Run(context.TODO()))
+	assert.Equal(t, []string{
+		"git push origin portal:refs/heads/pa-ir-portal --progress",
+		"git reset --quiet portal",
+		"git reset --hard base",
+	}, repo.Ran()[failed:])
+}
+
+// testPortalPullSagaFailure fails a pull once completed steps have run and
+// expects the fake repository back as it was.
+func testPortalPullSagaFailure(t *testing.T, portalBranch string, completed int) {
+	repo := fakeRepo(t)
+	repo.portal(portalBranch, "base")
+
+	pullSteps, err := PullSagaSteps("main", portalBranch, pullConfig("base"), PullOptions{}, false)
+	check(err)
+	pullSaga := saga.New(append(pullSteps[:completed:completed], saga.Step{
+		Name: "Boom!",
+		Run: func(context.Context) error {
+			return errors.New("uh oh!")
+		},
+	}))
+
+	var sagaError *saga.Error
+	assert.True(t, errors.As(pullSaga.Run(context.TODO()), &sagaError))
+	assert.Empty(t, sagaError.UndoErrs)
+
+	after := pullSteps[completed-1].Name
+	assert.Equal(t, "main", repo.branch, after)
+	assert.Equal(t, "base", repo.head, after)
+	assert.False(t, repo.staged, after)
+	assert.Equal(t, "portal", repo.origin[portalBranch], after)
+	assert.NotContains(t, repo.branches, portalBranch, after)
+}
+
+func TestPortalInLinkedWorktrees(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	rootDirectory := t.TempDir()
+	SetupBareGitRepository(t, rootDirectory)
+
+	gitIn := func(args ...string) {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+	pusherPath := filepath.Join(rootDirectory, "pusher")
+	gitIn("worktree", "add", "-b", "pairing", pusherPath)
+	check(os.Chdir(pusherPath))
+	gitIn("push", "--set-upstream", "origin", "pairing")
+	check(ioutil.WriteFile(fileName, []byte("foo\n"), 0644))
+
+	pushSteps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
 
-	assert.NotEmpty(t, errs)
 	assert.NoFileExists(t, fileName)
+	assert.True(t, CleanIndex(t))
 	assert.True(t, RemoteBranchExists(t, portalBranch))
 	assert.False(t, LocalBranchExists(t, portalBranch))
+	currentBranch, err := git.GetCurrentBranch()
+	check(err)
+	assert.Equal(t, "pairing", currentBranch)
+
+	check(os.Chdir(clone1Path))
+	mainBranch, err := git.GetCurrentBranch()
+	check(err)
+	assert.NotEqual(t, "pairing", mainBranch)
+
+	check(os.Chdir(rootDirectory))
+	CloneRepository(t, rootDirectory, "clone2")
+	pullerPath := filepath.Join(rootDirectory, "puller")
+	gitIn("worktree", "add", "--track", "-b", "pairing", pullerPath, "origin/pairing")
+	check(os.Chdir(pullerPath))
+
+	metaFileContents, err := git.ShowCommitMessage(portalBranch)
+	check(err)
+	config, err := GetConfiguration(metaFileContents)
+	check(err)
+
+	pullSteps, err := PullSagaSteps("pairing", portalBranch, *config, PullOptions{}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+
+	assert.FileExists(t, fileName)
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullRebaseOntoMovedBranch(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := push(t, portalBranch, fileName)
+	base := commitUpstream(t, "bar", "puller\n")
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Rebase: true}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.FileExists(t, fileName)
+	assert.FileExists(t, "bar")
+	assert.Equal(t, base, HeadSha(t))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullRebasePausesOnConflicts(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	base := commitUpstream(t, fileName, "puller\n")
+
+	paused := PausedPull{StartingBranch: currentBranch, PortalBranch: portalBranch, Config: pullConfig(sha), Options: PullOptions{Rebase: true}}
+	pullSteps, err := paused.Steps(false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	err = pullSaga.Run(context.TODO())
+
+	var pausedError *saga.PausedError
+	var conflicts *ConflictError
+	assert.True(t, errors.As(err, &pausedError))
+	assert.True(t, errors.As(err, &conflicts))
+	assert.Equal(t, []string{fileName}, conflicts.Files)
+	assert.True(t, git.RebaseInProgress())
+
+	dir := t.TempDir()
+	paused.Checkpoint = pullSaga.Checkpoint()
+	check(SavePausedPull(dir, paused))
+
+	check(ioutil.WriteFile(fileName, []byte("resolved\n"), 0644))
+	_, err = exec.Command("git", "add", fileName).Output()
+	check(err)
+
+	loaded, err := LoadPausedPull(dir)
+	check(err)
+	pullSteps, err = loaded.Steps(false)
+	check(err)
+	resumed := saga.New(pullSteps)
+	check(resumed.Restore(loaded.Checkpoint))
+
+	assert.NoError(t, resumed.Resume(context.TODO()))
+	assert.False(t, git.RebaseInProgress())
+	assert.Equal(t, base, HeadSha(t))
+	assert.False(t, CleanIndex(t))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+
+	contents, err := ioutil.ReadFile(fileName)
+	check(err)
+	assert.Equal(t, "resolved\n", string(contents))
+
+	check(RemovePausedPull(dir))
+	loaded, err = LoadPausedPull(dir)
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func TestPortalPullRebaseAbortAfterConflicts(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	base := commitUpstream(t, fileName, "puller\n")
+	_, err := exec.Command("git", "reset", "--hard", "HEAD^").Output()
+	check(err)
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Rebase: true}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	var pausedError *saga.PausedError
+	assert.True(t, errors.As(pullSaga.Run(context.TODO()), &pausedError))
+
+	resumed := saga.New(pullSteps)
+	check(resumed.Restore(pullSaga.Checkpoint()))
+
+	assert.NoError(t, resumed.Abort(context.TODO()))
+	assert.False(t, git.RebaseInProgress())
+	assert.NotEqual(t, base, HeadSha(t))
+	assert.Equal(t, sha, HeadSha(t))
 	assert.True(t, CleanIndex(t))
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullSagaWithAutostash(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := push(t, portalBranch, fileName)
+	check(ioutil.WriteFile("notes", []byte("scratch\n"), 0644))
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Autostash: true}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.FileExists(t, fileName)
+	assert.FileExists(t, "notes")
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+
+	stashes, err := StashEntries()
+	check(err)
+	assert.Empty(t, stashes)
+
+	autostash, err := AutostashOf(pullSaga.State())
+	check(err)
+	assert.Equal(t, []string{"notes"}, autostash.Files)
+	assert.Empty(t, autostash.Conflicts)
+}
+
+func TestPortalPullSagaWithAutostashConflicts(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	rootDirectory := t.TempDir()
+	SetupBareGitRepository(t, rootDirectory)
+
+	clone1Path := CloneRepository(t, rootDirectory, "clone1")
+	commitUpstream(t, fileName, "base\n")
+
+	check(os.Chdir(rootDirectory))
+	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
+	sha := HeadSha(t)
+	check(ioutil.WriteFile(fileName, []byte("pusher\n"), 0644))
+	pushSteps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(pushSteps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+
+	check(os.Chdir(clone1Path))
+	check(git.Fetch())
+	check(ioutil.WriteFile(fileName, []byte("puller\n"), 0644))
+
+	pullSteps, err := PullSagaSteps("master", portalBranch, pullConfig(sha), PullOptions{Autostash: true}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+	assert.Equal(t, sha, HeadSha(t))
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+
+	autostash, err := AutostashOf(pullSaga.State())
+	check(err)
+	assert.Equal(t, []string{fileName}, autostash.Conflicts)
+
+	contents, err := ioutil.ReadFile(fileName)
+	check(err)
+	assert.Contains(t, string(contents), "<<<<<<<")
+
+	stashes, err := StashEntries()
+	check(err)
+	assert.Len(t, stashes, 1)
+	assert.Equal(t, autostash.Sha, stashes[0].Sha)
+
+	residual, err := PullResidual("master", portalBranch, sha)
+	check(err)
+	assert.Equal(t, "stash@{0}", residual.Autostash)
+}
+
+func TestPortalPullSagaRollsBackAutostash(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := push(t, portalBranch, fileName)
+	check(ioutil.WriteFile("notes", []byte("scratch\n"), 0644))
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Autostash: true}, false)
+	check(err)
+	pullSaga := saga.New(append(pullSteps, saga.Step{
+		Name: "Boom!",
+		Run: func(context.Context) error {
+			return errors.New("uh oh!")
+		},
+	}))
+
+	assert.Error(t, pullSaga.Run(context.TODO()))
+	assert.NoFileExists(t, fileName)
+	assert.FileExists(t, "notes")
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+
+	stashes, err := StashEntries()
+	check(err)
+	assert.Empty(t, stashes)
+}
+
+func TestPortalPullOntoAnotherBranch(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	base := HeadSha(t)
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Onto: "renamed"}, false)
+	check(err)
+	pullSaga := saga.New(pullSteps)
+
+	assert.NoError(t, pullSaga.Run(context.TODO()))
+
+	branch, err := git.GetCurrentBranch()
+	check(err)
+	assert.Equal(t, "renamed", branch)
+	assert.Equal(t, base, HeadSha(t))
+	assert.FileExists(t, fileName)
+	assert.False(t, RemoteBranchExists(t, portalBranch))
+
+	state := map[string]string{}
+	for key, value := range pullSaga.State().Values() {
+		state[string(key)] = value
+	}
+	moves := Moves(state)
+	assert.Len(t, moves, 3)
+	assert.Equal(t, "onto branch renamed", moves[0])
+	assert.Equal(t, fmt.Sprintf("base %s -> %s", sha, base), moves[1])
+}
+
+func TestPortalPullOntoAnotherBranchRollsBack(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	base := HeadSha(t)
+
+	pullSteps, err := PullSagaSteps(currentBranch, portalBranch, pullConfig(sha), PullOptions{Onto: "renamed"}, false)
+	check(err)
+	pullSaga := saga.New(append(pullSteps, saga.Step{
+		Name: "Boom!",
+		Run: func(context.Context) error {
+			return errors.New("uh oh!")
+		},
+	}))
+
+	assert.Error(t, pullSaga.Run(context.TODO()))
+
+	branch, err := git.GetCurrentBranch()
+	check(err)
+	assert.Equal(t, currentBranch, branch)
+	assert.Equal(t, base, HeadSha(t))
+	assert.False(t, LocalBranchExists(t, "renamed"))
+	assert.NoFileExists(t, fileName)
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestMovesOnlyForRebasedPulls(t *testing.T) {
+	assert.Empty(t, Moves(map[string]string{"portalCommitSha": "abc", "remotePortalCommitSha": "def"}))
+	assert.Equal(t, []string{
+		"base a -> b",
+		"portal commit c -> d",
+	}, Moves(map[string]string{"pusherBaseCommitSha": "a", "baseCommitSha": "b", "remotePortalCommitSha": "c", "portalCommitSha": "d"}))
+}
+
+func TestPortalPullCheckPredictsConflicts(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	base := commitUpstream(t, fileName, "puller\n")
+	check(ioutil.WriteFile("untracked", []byte("mine\n"), 0644))
+
+	config := pullConfig(sha)
+	config.Meta.WorkingBranch = currentBranch
+	result, err := CheckPull(config, "origin/"+portalBranch, "origin/"+currentBranch, "")
+	check(err)
+
+	assert.False(t, result.Clean())
+	assert.Equal(t, "origin/"+currentBranch, result.Target)
+	assert.Equal(t, 1, result.Behind)
+	assert.Equal(t, []string{fileName}, result.Files)
+	assert.Equal(t, []string{fileName}, result.Conflicts)
+
+	assert.Equal(t, base, HeadSha(t))
+	assert.False(t, git.RebaseInProgress())
+	status, err := exec.Command("git", "status", "--porcelain=v1").Output()
+	check(err)
+	assert.Equal(t, "?? untracked\n", string(status))
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPullCheckCleanOntoAnotherBranch(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	fileName := "foo"
+
+	currentBranch, sha := pushWith(t, portalBranch, fileName, "pusher\n")
+	commitUpstream(t, "bar", "puller\n")
+
+	config := pullConfig(sha)
+	config.Meta.WorkingBranch = currentBranch
+	result, err := CheckPull(config, "origin/"+portalBranch, "origin/"+currentBranch, "feature")
+	check(err)
+
+	assert.True(t, result.Clean())
+	assert.Equal(t, "HEAD", result.Target)
+	assert.Equal(t, 1, result.Behind)
+	assert.Empty(t, result.Conflicts)
+	assert.False(t, LocalBranchExists(t, "feature"))
+}
+
+// commitUpstream moves origin's working branch on with a commit of fileName,
+// returning its sha.
+func commitUpstream(t *testing.T, fileName string, contents string) string {
+	t.Helper()
+
+	check(ioutil.WriteFile(fileName, []byte(contents), 0644))
+	for _, args := range [][]string{{"add", fileName}, {"commit", "--message", "upstream"}, {"push", "origin", "HEAD"}} {
+		_, err := exec.Command("git", args...).Output()
+		check(err)
+	}
+
+	return HeadSha(t)
 }
 
 func push(t *testing.T, portalBranch string, fileName string) (string, string) {
+	return pushWith(t, portalBranch, fileName, "")
+}
+
+// pushWith pushes fileName holding contents from a second clone, returning
+// the working branch and the sha the portal commit is based on.
+func pushWith(t *testing.T, portalBranch string, fileName string, contents string) (string, string) {
+	return pushAfter(t, portalBranch, func() {
+		check(ioutil.WriteFile(fileName, []byte(contents), 0644))
+	})
+}
+
+// pushUnpublished pushes fileName from a second clone on top of a commit the
+// clone never pushed to its working branch.
+func pushUnpublished(t *testing.T, portalBranch string, fileName string) (string, string) {
+	return pushAfter(t, portalBranch, func() {
+		check(ioutil.WriteFile("unpublished", []byte("unpublished\n"), 0644))
+		for _, args := range [][]string{{"add", "unpublished"}, {"commit", "--message", "unpublished"}} {
+			_, err := exec.Command("git", args...).Output()
+			check(err)
+		}
+		check(ioutil.WriteFile(fileName, []byte{}, 0644))
+	})
+}
+
+// pushAfter pushes from a second clone once change has run in it.
+func pushAfter(t *testing.T, portalBranch string, change func()) (string, string) {
 	rootDirectory := t.TempDir()
 
 	SetupBareGitRepository(t, rootDirectory)
@@ -110,17 +916,15 @@ func push(t *testing.T, portalBranch string, fileName string) (string, string) {
 
 	check(os.Chdir(rootDirectory))
 	check(os.Chdir(CloneRepository(t, rootDirectory, "clone2")))
-	fileHandle, err := os.Create(fileName)
-	check(err)
-	defer fileHandle.Close()
+	change()
 
-	pushSteps, err := PushSagaSteps(context.TODO(), portalBranch, "v1.0.0", false, "")
+	pushSteps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
 	if err != nil {
 		t.FailNow()
 	}
 	pushSaga := saga.New(pushSteps)
-	errors := pushSaga.Run()
-	assert.Empty(t, errors)
+	err = pushSaga.Run(context.TODO())
+	assert.NoError(t, err)
 
 	remoteTrackingBranch, err := git.GetRemoteTrackingBranch()
 	if err != nil {
@@ -140,3 +944,12 @@ func push(t *testing.T, portalBranch string, fileName string) (string, string) {
 
 	return currentBranch, sha
 }
+
+func submoduleHead(t *testing.T, path string) string {
+	t.Helper()
+
+	sha, err := git.SubmoduleHead(".", path)
+	check(err)
+
+	return sha
+}
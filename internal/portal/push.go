@@ -2,107 +2,190 @@ package portal
 
 import (
 	"context"
-	"os/exec"
+	"fmt"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/ericTsiliacos/portal/internal/git"
 	"github.com/ericTsiliacos/portal/internal/saga"
-	"github.com/ericTsiliacos/portal/internal/shell"
 )
 
-func PushSagaSteps(ctx context.Context, portalBranch string, version string, verbose bool, commitMessage string) (steps []saga.Step, err error) {
+// PushMeta fills in the parts of the meta that describe where the pusher is:
+// the working branch and the sha it diverges from the remote at.
+func PushMeta(config Meta) (Meta, error) {
 	remoteTrackingBranch, err := git.GetRemoteTrackingBranch()
 	if err != nil {
-		return
+		return config, err
+	}
+
+	if config.Meta.WorkingBranch, err = git.GetCurrentBranch(); err != nil {
+		return config, err
 	}
 
-	currentBranch, err := git.GetCurrentBranch()
+	config.Meta.Sha, err = git.GetBoundarySha(remoteTrackingBranch, config.Meta.WorkingBranch)
+
+	return config, err
+}
+
+func PushSagaSteps(portalBranch string, config Meta, hooks Hooks, verbose bool) (steps []saga.Step, err error) {
+	remoteTrackingBranch, err := git.GetRemoteTrackingBranch()
 	if err != nil {
 		return
 	}
 
-	sha, err := git.GetBoundarySha(remoteTrackingBranch, currentBranch)
+	if config, err = PushMeta(config); err != nil {
+		return
+	}
+
+	currentBranch := config.Meta.WorkingBranch
+
+	stage := commandStep(verbose, "git add -A",
+		[]gitCommand{{"add", "--all"}},
+		[]gitCommand{{"reset"}})
+	if config.Meta.Kept {
+		stage = recordingIndex(stage)
+	}
+
+	steps = []saga.Step{
+		ensures(requires(stage, onBranch(currentBranch)), everythingStaged()),
+	}
+
+	if len(config.Meta.IgnoredFiles) > 0 {
+		pathspecs := git.TopLevelPathspecs(config.Meta.IgnoredFiles)
+
+		steps = append(steps, ensures(commandStep(verbose, "git add ignored files",
+			[]gitCommand{append(gitCommand{"add", "--force", "--"}, pathspecs...)},
+			[]gitCommand{append(gitCommand{"reset", "--quiet", "--"}, pathspecs...)}),
+			tracked(pathspecs, true)))
+	}
+
+	clearSubmodules, err := clearSubmoduleSteps(config.Meta.Submodules, verbose)
 	if err != nil {
 		return
 	}
 
-	return []saga.Step{
-		{
-			Name: "git add -A",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "add", "--all"), verbose)
-			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "reset"), verbose)
-			},
-		},
-		{
+	steps = append(steps, []saga.Step{
+		ensures(saga.Step{
 			Name: "git commit -m 'portal-wip'",
-			Run: func() (err error) {
-				config := Meta{}
-				config.Meta.WorkingBranch = currentBranch
-				config.Meta.Sha = sha
-				config.Meta.Version = version
-				config.Meta.Message = commitMessage
+			Run: func(ctx context.Context) (err error) {
+				if err = hooks.preCommit(ctx, verbose); err != nil {
+					return
+				}
+
+				if config.Meta.Tree, err = git.WriteTree(); err != nil {
+					return
+				}
 
 				data, marshalError := yaml.Marshal(&config)
 				if marshalError != nil {
-					return err
+					return marshalError
 				}
 
-				return shell.Run(exec.CommandContext(ctx, "git", "commit", "--allow-empty", "-m", string(data)), verbose)
-			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "reset", "HEAD^"), verbose)
-			},
-		},
-		{
-			Name: "git checkout portal branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "checkout", "-b", portalBranch, "--progress"), verbose)
-			},
-			Undo: func() (err error) {
-				if err = shell.Run(exec.Command("git", "checkout", currentBranch, "--progress"), verbose); err != nil {
+				message, err := hooks.commitMsg(ctx, string(data), verbose)
+				if err != nil {
 					return
 				}
 
-				if err = shell.Run(exec.Command("git", "branch", "-D", portalBranch), verbose); err != nil {
+				if err = record(ctx, workingCommit, "HEAD"); err != nil {
 					return
 				}
 
-				return
-			},
-		},
-		{
-			Name: "git push portal branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "push", "origin", portalBranch, "--progress"), verbose)
+				if err = hooks.commitArgs(message).run(ctx, verbose); err != nil {
+					return
+				}
+
+				return record(ctx, portalCommit, "HEAD")
 			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "push", "origin", "--delete", portalBranch, "--progress"), verbose)
+			Undo: func(ctx context.Context) (err error) {
+				return recorded(ctx, workingCommit, verbose, func(sha string) gitCommand {
+					return gitCommand{"reset", sha}
+				})
 			},
+			Commands:     hooks.commitCommands(),
+			UndoCommands: []string{fmt.Sprintf("git reset <%s>", workingCommit)},
+		}, headIsPortalCommit()),
+		ensures(requires(commandStep(verbose, "git checkout portal branch",
+			[]gitCommand{{"checkout", "-b", portalBranch, "--progress"}},
+			[]gitCommand{{"checkout", currentBranch, "--progress"}, {"branch", "-D", portalBranch}}),
+			localBranchExists(portalBranch, false)),
+			onBranch(portalBranch)),
+	}...)
+
+	steps = append(steps, networkStep(ensures(saga.Step{
+		Name: "git push portal branch",
+		Run: func(ctx context.Context) error {
+			return gitCommand{"push", "origin", portalBranch, "--progress"}.run(ctx, verbose)
 		},
-		{
-			Name: "git checkout to original branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "checkout", "-", "--progress"), verbose)
-			},
+		Undo: func(ctx context.Context) error {
+			return recorded(ctx, portalCommit, verbose, func(sha string) gitCommand {
+				return deleteRemoteBranch(portalBranch, sha)
+			})
 		},
-		{
+		Abort:        abortPush(portalBranch, verbose),
+		Commands:     []string{gitCommand{"push", "origin", portalBranch, "--progress"}.String()},
+		UndoCommands: []string{deleteRemoteBranch(portalBranch, fmt.Sprintf("<%s>", portalCommit)).String()},
+	}, remoteBranchAt(portalBranch, portalCommit))))
+
+	steps = append(steps, pushStashSteps(portalBranch, config.Meta.Stashes, verbose)...)
+
+	steps = append(steps, []saga.Step{
+		ensures(commandStep(verbose, "git checkout to original branch",
+			[]gitCommand{{"checkout", currentBranch, "--progress"}},
+			nil),
+			onBranch(currentBranch)),
+		ensures(saga.Step{
 			Name: "delete local portal branch",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "branch", "-D", portalBranch), verbose)
+			Run: func(ctx context.Context) error {
+				return gitCommand{"branch", "-D", portalBranch}.run(ctx, verbose)
 			},
-			Undo: func() (err error) {
-				return shell.Run(exec.Command("git", "checkout", "-b", portalBranch, "--progress"), verbose)
+			Undo: func(ctx context.Context) error {
+				return recorded(ctx, portalCommit, verbose, func(sha string) gitCommand {
+					return gitCommand{"branch", portalBranch, sha}
+				})
 			},
+			Commands:     []string{gitCommand{"branch", "-D", portalBranch}.String()},
+			UndoCommands: []string{fmt.Sprintf("git branch %s <%s>", portalBranch, portalCommit)},
+		}, localBranchExists(portalBranch, false)),
+	}...)
+
+	if config.Meta.Kept {
+		return append(steps, restoreWorkspaceStep(verbose)), nil
+	}
+
+	steps = append(steps, ensures(commandStep(verbose, "clear git workspace",
+		[]gitCommand{{"reset", "--hard", remoteTrackingBranch}},
+		nil),
+		headAt(remoteTrackingBranch), cleanWorkingTree()))
+
+	steps = append(steps, clearSubmodules...)
+
+	return append(steps, dropStashSteps(config.Meta.Stashes, verbose)...), nil
+}
+
+// restoreWorkspaceStep takes the working branch back to before the portal
+// commit with the index as it was staged, for a pusher who keeps the work.
+// The working tree was never changed.
+func restoreWorkspaceStep(verbose bool) saga.Step {
+	return ensures(saga.Step{
+		Name: "restore git workspace",
+		Run: func(ctx context.Context) error {
+			err := recorded(ctx, workingCommit, verbose, func(sha string) gitCommand {
+				return gitCommand{"reset", "--quiet", sha}
+			})
+			if err != nil {
+				return err
+			}
+
+			return recorded(ctx, workingIndex, verbose, func(tree string) gitCommand {
+				return gitCommand{"read-tree", tree}
+			})
 		},
-		{
-			Name: "clear git workspace",
-			Run: func() (err error) {
-				return shell.Run(exec.CommandContext(ctx, "git", "reset", "--hard", remoteTrackingBranch), verbose)
-			},
+		Undo: func(ctx context.Context) error {
+			return recorded(ctx, portalCommit, verbose, func(sha string) gitCommand {
+				return gitCommand{"reset", "--quiet", sha}
+			})
 		},
-	}, nil
+		Commands:     []string{fmt.Sprintf("git reset --quiet <%s>", workingCommit), fmt.Sprintf("git read-tree <%s>", workingIndex)},
+		UndoCommands: []string{fmt.Sprintf("git reset --quiet <%s>", portalCommit)},
+	}, headAtRecorded(workingCommit))
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/ericTsiliacos/portal/internal/git"
 	"github.com/ericTsiliacos/portal/internal/saga"
 )
 
@@ -18,70 +22,351 @@ func TestPortalPushSaga(t *testing.T) {
 
 	pushSetup(t, fileName)
 
-	steps, err := PushSagaSteps(context.TODO(), portalBranch, "v1.0.0", false, "")
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
 	if err != nil {
 		t.FailNow()
 	}
 	saga := saga.New(steps)
-	errors := saga.Run()
+	err = saga.Run(context.TODO())
 
-	assert.Empty(t, errors)
+	assert.NoError(t, err)
 	assert.NoFileExists(t, fileName)
 	assert.True(t, RemoteBranchExists(t, portalBranch))
 	assert.False(t, LocalBranchExists(t, portalBranch))
 	assert.True(t, CleanIndex(t))
 }
 
-func TestPortalPushSagaWithFailures(t *testing.T) {
+func TestPortalPushSagaKeepingTheWork(t *testing.T) {
 	fileName := "foo"
 	portalBranch := "pa-ir-portal"
 
 	pushSetup(t, fileName)
-	steps, err := PushSagaSteps(context.TODO(), portalBranch, "v1.0.0", false, "")
-	if err != nil {
-		t.FailNow()
+	check(ioutil.WriteFile("committed", []byte("unpublished\n"), 0644))
+	for _, args := range [][]string{{"add", "committed"}, {"commit", "--message", "unpublished"}} {
+		_, err := exec.Command("git", args...).Output()
+		check(err)
 	}
+	check(ioutil.WriteFile("staged", []byte("staged\n"), 0644))
+	_, err := exec.Command("git", "add", "staged").Output()
+	check(err)
 
-	for i := 1; i < len(steps); i++ {
-		fmt.Printf("test run %d:", i)
-		fmt.Println()
-		fmt.Println("-----------------")
+	head := HeadSha(t)
+	status, err := exec.Command("git", "status", "--porcelain=v1").Output()
+	check(err)
 
-		testPortalPushSagaFailure(t, portalBranch, i)
-	}
+	config := pushConfig()
+	config.Meta.Kept = true
+	steps, err := PushSagaSteps(portalBranch, config, Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(steps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+	assert.False(t, LocalBranchExists(t, portalBranch))
+	assert.Equal(t, head, HeadSha(t))
+
+	kept, err := exec.Command("git", "status", "--porcelain=v1").Output()
+	check(err)
+	assert.Equal(t, string(status), string(kept))
+
+	metaFileContents, err := git.ShowCommitMessage(portalBranch)
+	check(err)
+	meta, err := GetConfiguration(metaFileContents)
+	check(err)
+	assert.True(t, meta.Meta.Kept)
 }
 
-func testPortalPushSagaFailure(t *testing.T, portalBranch string, index int) {
-	rootDirectory := t.TempDir()
+func TestPortalPushSagaBacksUpTheWorkspace(t *testing.T) {
+	fileName := "foo"
+	portalBranch := "pa-ir-portal"
 
-	SetupBareGitRepository(t, rootDirectory)
+	pushSetup(t, fileName)
+	check(ioutil.WriteFile(fileName, []byte("work in progress\n"), 0644))
 
-	check(os.Chdir(CloneRepository(t, rootDirectory, "clone1")))
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(steps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+	assert.NoFileExists(t, fileName)
+
+	backups, err := git.Backups()
+	check(err)
+	assert.Len(t, backups, 1)
+	assert.Contains(t, backups[0].Reason, "git reset --hard")
+
+	check(git.RestoreBackup(backups[0]))
+	contents, err := ioutil.ReadFile(fileName)
+	check(err)
+	assert.Equal(t, "work in progress\n", string(contents))
+}
+
+func TestBackupsIncludeConfiguredIgnoredFiles(t *testing.T) {
+	fileName := ".env"
+
+	pushSetup(t, "foo")
+	IgnoreFile(t, fileName)
+	check(ioutil.WriteFile(fileName, []byte("SECRET=mine\n"), 0644))
+	_, err := exec.Command("git", "config", "portal.includeIgnored", fileName).Output()
+	check(err)
+
+	backup, err := git.CreateBackup("", "test")
+	check(err)
+	check(os.Remove(fileName))
 
+	check(git.RestoreBackup(backup))
+	contents, err := ioutil.ReadFile(fileName)
+	check(err)
+	assert.Equal(t, "SECRET=mine\n", string(contents))
+}
+
+func TestBackupsAreKeptPerWorktree(t *testing.T) {
+	pushSetup(t, "foo")
+	linked := filepath.Join(t.TempDir(), "linked")
+	_, err := exec.Command("git", "worktree", "add", "-b", "linked", linked).Output()
+	check(err)
+
+	main, err := git.CreateBackup("", "main")
+	check(err)
+	_, err = git.CreateBackup(linked, "linked")
+	check(err)
+
+	backups, err := git.Backups()
+	check(err)
+	assert.Equal(t, []git.Backup{main}, backups)
+
+	check(os.Chdir(linked))
+	last, err := git.FindBackup("last")
+	check(err)
+	assert.Equal(t, "linked", last.Reason)
+	assert.NotEqual(t, main.Ref(), last.Ref())
+}
+
+func TestPortalPushSagaBackupRetention(t *testing.T) {
 	fileName := "foo"
-	fileHandle, err := os.Create(fileName)
+	portalBranch := "pa-ir-portal"
+
+	pushSetup(t, fileName)
+	_, err := exec.Command("git", "config", "portal.backupRetention", "0").Output()
 	check(err)
-	defer fileHandle.Close()
 
-	steps, err := PushSagaSteps(context.TODO(), portalBranch, "v1.0.0", false, "")
-	if err != nil {
-		t.FailNow()
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	pushSaga := saga.New(steps)
+	assert.NoError(t, pushSaga.Run(context.TODO()))
+
+	backups, err := git.Backups()
+	check(err)
+	assert.Empty(t, backups)
+
+	_, err = exec.Command("git", "config", "portal.backupRetention", "1").Output()
+	check(err)
+	check(BackUpWorktree("first"))
+	check(BackUpWorktree("second"))
+
+	backups, err = git.Backups()
+	check(err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, "second", backups[0].Reason)
+}
+
+func TestCompensationsSkipBackups(t *testing.T) {
+	repo := fakeRepo(t)
+	repo.On("config", "--get", "portal.backupRetention").Returns("many\n")
+
+	reset := gitCommand{"reset", "--hard", "--quiet"}
+	assert.Error(t, reset.run(context.TODO(), false))
+	assert.NotContains(t, repo.Ran(), "git reset --hard --quiet")
+
+	steps := []saga.Step{
+		{Name: "reset", Run: func(context.Context) error { return nil }, Undo: func(ctx context.Context) error {
+			return reset.run(ctx, false)
+		}},
+		{Name: "boom!", Run: func(context.Context) error { return errors.New("uh oh!") }},
 	}
-	steps = steps[0 : len(steps)-index]
-	stepsWithError := append(steps, saga.Step{
+	s := saga.New(steps)
+
+	var sagaError *saga.Error
+	assert.True(t, errors.As(s.Run(context.TODO()), &sagaError))
+	assert.Equal(t, []string{"reset"}, sagaError.Undone)
+	assert.Empty(t, sagaError.UndoErrs)
+	assert.Contains(t, repo.Ran(), "git reset --hard --quiet")
+}
+
+func TestPortalPushSagaPlan(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+	repo := fakeRepo(t)
+
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.Name)
+		assert.NotEmpty(t, step.Commands, step.Name)
+		if step.Undo != nil {
+			assert.NotEmpty(t, step.UndoCommands, step.Name)
+		}
+	}
+	assert.Equal(t, []string{
+		"git add -A",
+		"git commit -m 'portal-wip'",
+		"git checkout portal branch",
+		"git push portal branch",
+		"git checkout to original branch",
+		"delete local portal branch",
+		"clear git workspace",
+	}, names)
+	assert.Equal(t, []string{"git checkout -b pa-ir-portal --progress"}, steps[2].Commands)
+	assert.Equal(t, "base", repo.head)
+	assert.False(t, repo.staged)
+	assert.NotContains(t, repo.branches, portalBranch)
+	assert.NotContains(t, repo.origin, portalBranch)
+}
+
+func TestPortalPushSagaWithFailures(t *testing.T) {
+	portalBranch := "pa-ir-portal"
+
+	fakeRepo(t)
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+
+	for completed := 1; completed < len(steps); completed++ {
+		testPortalPushSagaFailure(t, portalBranch, completed)
+	}
+}
+
+func TestPortalPushSagaUndoesInReverse(t *testing.T) {
+	repo := fakeRepo(t)
+
+	steps, err := PushSagaSteps("pa-ir-portal", pushConfig(), Hooks{Policy: BypassHooks}, false)
+	check(err)
+	failed := 0
+	pushSaga := saga.New(append(steps, saga.Step{
+		Name: "Boom!",
+		Run: func(context.Context) error {
+			failed = len(repo.Ran())
+			return errors.New("uh oh!")
+		},
+	}))
+
+	assert.Error(t, pushSaga.Run(context.TODO()))
+	assert.Equal(t, []string{
+		"git branch pa-ir-portal portal",
+		"git push origin --delete --force-with-lease=refs/heads/pa-ir-portal:portal pa-ir-portal --progress",
+		"git checkout main --progress",
+		"git branch -D pa-ir-portal",
+		"git reset base",
+		"git reset",
+	}, repo.Ran()[failed:])
+}
+
+// testPortalPushSagaFailure fails a push once completed steps have run and
+// expects the fake repository back as it was.
+func testPortalPushSagaFailure(t *testing.T, portalBranch string, completed int) {
+	repo := fakeRepo(t)
+
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{Policy: BypassHooks}, false)
+	check(err)
+	pushSaga := saga.New(append(steps[:completed:completed], saga.Step{
 		Name: "Boom!",
-		Run: func() error {
+		Run: func(context.Context) error {
 			return errors.New("uh oh!")
 		},
-	})
-	saga := saga.New(stepsWithError)
-	errors := saga.Run()
+	}))
+
+	var sagaError *saga.Error
+	assert.True(t, errors.As(pushSaga.Run(context.TODO()), &sagaError))
+	assert.Empty(t, sagaError.UndoErrs)
+
+	after := steps[completed-1].Name
+	assert.Equal(t, "main", repo.branch, after)
+	assert.Equal(t, "base", repo.head, after)
+	assert.False(t, repo.staged, after)
+	assert.NotContains(t, repo.branches, portalBranch, after)
+	assert.NotContains(t, repo.origin, portalBranch, after)
+}
+
+func TestPortalPushHookPolicies(t *testing.T) {
+	fileName := "foo"
+	portalBranch := "pa-ir-portal"
+
+	pushWith := func(hooks Hooks) error {
+		pushSetup(t, fileName)
+		InstallHook(t, "pre-commit", "echo 'lint failed' >&2\nexit 1")
+
+		steps, err := PushSagaSteps(portalBranch, pushConfig(), hooks, false)
+		check(err)
+		s := saga.New(steps)
+
+		return s.Run(context.TODO())
+	}
 
-	assert.NotEmpty(t, errors)
+	var hookError *git.HookError
+	err := pushWith(Hooks{Policy: RunHooks})
+	assert.True(t, errors.As(err, &hookError))
+	assert.Equal(t, "pre-commit", hookError.Hook)
+	assert.Contains(t, hookError.Output, "lint failed")
 	assert.FileExists(t, fileName)
 	assert.False(t, RemoteBranchExists(t, portalBranch))
-	assert.False(t, LocalBranchExists(t, portalBranch))
-	assert.False(t, CleanIndex(t))
+
+	reported := []error{}
+	err = pushWith(Hooks{Policy: ReportHooks, Reported: func(err error) { reported = append(reported, err) }})
+	assert.NoError(t, err)
+	assert.Len(t, reported, 1)
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+
+	assert.NoError(t, pushWith(Hooks{Policy: BypassHooks}))
+	assert.True(t, RemoteBranchExists(t, portalBranch))
+}
+
+func TestPortalPushKeepsCommitMsgHookEdits(t *testing.T) {
+	fileName := "foo"
+	portalBranch := "pa-ir-portal"
+
+	pushSetup(t, fileName)
+	InstallHook(t, "commit-msg", "printf '\\nChange-Id: I1234\\n' >> \"$1\"")
+
+	steps, err := PushSagaSteps(portalBranch, pushConfig(), Hooks{}, false)
+	check(err)
+	s := saga.New(steps)
+	assert.NoError(t, s.Run(context.TODO()))
+
+	_, err = git.Run(context.TODO(), git.Command{Args: []string{"fetch"}})
+	check(err)
+	message, err := git.ShowCommitMessage(portalBranch)
+	check(err)
+	assert.Contains(t, message, "Change-Id: I1234")
+	assert.True(t, isPortalCommit(message))
+}
+
+func TestPortalPushDropsOnlyThePushedStashes(t *testing.T) {
+	pushSetup(t, "foo")
+
+	stash := func(message string) {
+		check(ioutil.WriteFile(message, []byte(message+"\n"), 0644))
+		_, err := exec.Command("git", "stash", "push", "--include-untracked", "--message", message).Output()
+		check(err)
+	}
+
+	stash("first")
+	stash("second")
+	stashes, err := StashEntries()
+	check(err)
+	stash("later")
+	branch, _ := git.GetCurrentBranch()
+
+	failing := saga.New(append(dropStashSteps(stashes, false), saga.Step{
+		Name: "Boom!",
+		Run: func(context.Context) error {
+			return errors.New("uh oh!")
+		},
+	}))
+	assert.Error(t, failing.Run(context.TODO()))
+	assert.Equal(t, fmt.Sprintf("stash@{0}: On %[1]s: second\nstash@{1}: On %[1]s: first\nstash@{2}: On %[1]s: later\n", branch), StashList(t))
+
+	dropping := saga.New(dropStashSteps(stashes, false))
+	assert.NoError(t, dropping.Run(context.TODO()))
+	assert.Equal(t, fmt.Sprintf("stash@{0}: On %s: later\n", branch), StashList(t))
 }
 
 func pushSetup(t *testing.T, fileName string) {
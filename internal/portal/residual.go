@@ -0,0 +1,203 @@
+package portal
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+)
+
+// Residual is what a push or pull left behind after some of its
+// compensations failed.
+type Residual struct {
+	Push             bool
+	WorkingBranch    string
+	PortalBranch     string
+	StartingSha      string
+	CurrentBranch    string
+	RebaseInProgress bool
+	PortalCommit     bool
+	StagedChanges    bool
+	LocalChanges     bool
+	LocalBranch      bool
+	RemoteBranches   []string
+	Autostash        string
+}
+
+func PushResidual(workingBranch string, portalBranch string) (Residual, error) {
+	residual, err := residualState(workingBranch, portalBranch)
+	residual.Push = true
+
+	return residual, err
+}
+
+func PullResidual(workingBranch string, portalBranch string, startingSha string) (Residual, error) {
+	residual, err := residualState(workingBranch, portalBranch)
+	residual.StartingSha = startingSha
+	if err != nil {
+		return residual, err
+	}
+
+	residual.Autostash, err = autostashRef()
+
+	return residual, err
+}
+
+// autostashRef is the stash entry a pull stashed the puller's changes in,
+// if one is left.
+func autostashRef() (string, error) {
+	stashes, err := StashEntries()
+	if err != nil {
+		return "", err
+	}
+
+	for _, stash := range stashes {
+		if strings.HasSuffix(stash.Message, ": "+autostashMessage) {
+			return git.StashRef(stash.Sha)
+		}
+	}
+
+	return "", nil
+}
+
+func (r Residual) State() (state []string) {
+	if r.RebaseInProgress {
+		state = append(state, "a rebase is in progress")
+	}
+
+	if r.CurrentBranch != r.WorkingBranch {
+		state = append(state, fmt.Sprintf("HEAD is on %s instead of %s", r.CurrentBranch, r.WorkingBranch))
+	}
+
+	if r.PortalCommit {
+		state = append(state, fmt.Sprintf("%s still ends with the portal work in progress commit", r.WorkingBranch))
+	}
+
+	if r.StagedChanges {
+		state = append(state, "the index was not reset, changes are still staged")
+	}
+
+	if r.LocalBranch {
+		state = append(state, fmt.Sprintf("local branch %s exists", r.PortalBranch))
+	}
+
+	for _, remoteBranch := range r.RemoteBranches {
+		state = append(state, fmt.Sprintf("remote branch origin/%s exists", remoteBranch))
+	}
+
+	if r.Autostash != "" {
+		state = append(state, fmt.Sprintf("your own changes are stashed in %s", r.Autostash))
+	}
+
+	return
+}
+
+func (r Residual) RecoveryCommands() (commands []string) {
+	if r.RebaseInProgress {
+		commands = append(commands, "git rebase --abort")
+	}
+
+	if r.CurrentBranch != r.WorkingBranch {
+		commands = append(commands, fmt.Sprintf("git checkout %s", r.WorkingBranch))
+	}
+
+	if r.Push {
+		return append(commands, r.pushRecoveryCommands()...)
+	}
+
+	return append(commands, r.pullRecoveryCommands()...)
+}
+
+func (r Residual) pushRecoveryCommands() (commands []string) {
+	if r.PortalCommit {
+		commands = append(commands, "git reset HEAD^")
+	} else if r.StagedChanges {
+		commands = append(commands, "git reset")
+	}
+
+	if r.LocalBranch {
+		commands = append(commands, fmt.Sprintf("git branch -D %s", r.PortalBranch))
+	}
+
+	workIsLocal := r.PortalCommit || r.LocalChanges
+
+	for _, remoteBranch := range r.RemoteBranches {
+		if workIsLocal {
+			commands = append(commands, fmt.Sprintf("git push origin --delete %s", remoteBranch))
+		}
+	}
+
+	if !workIsLocal && r.portalOpen() {
+		commands = append(commands, "portal pull")
+	}
+
+	return
+}
+
+func (r Residual) pullRecoveryCommands() (commands []string) {
+	pull := "portal pull"
+	if r.Autostash != "" {
+		pull = "portal pull --autostash"
+	}
+
+	if r.portalOpen() && r.StartingSha != "" {
+		commands = append(commands, fmt.Sprintf("git reset --hard %s", r.StartingSha))
+	}
+
+	if r.Autostash != "" {
+		commands = append(commands, fmt.Sprintf("git stash pop --index %s", r.Autostash))
+	}
+
+	if r.portalOpen() && r.StartingSha != "" {
+		commands = append(commands, pull)
+	}
+
+	return
+}
+
+func (r Residual) portalOpen() bool {
+	for _, remoteBranch := range r.RemoteBranches {
+		if remoteBranch == r.PortalBranch {
+			return true
+		}
+	}
+
+	return false
+}
+
+func residualState(workingBranch string, portalBranch string) (residual Residual, err error) {
+	residual.WorkingBranch = workingBranch
+	residual.PortalBranch = portalBranch
+
+	if residual.CurrentBranch, err = git.GetCurrentBranch(); err != nil {
+		return
+	}
+
+	residual.RebaseInProgress = git.RebaseInProgress()
+	residual.StagedChanges = git.StagedChanges()
+
+	if residual.LocalChanges, err = git.DirtyIndex(); err != nil {
+		return
+	}
+
+	if residual.LocalBranch, err = git.LocalBranchExists(portalBranch); err != nil {
+		return
+	}
+
+	if message, err := git.CommitMessage(workingBranch); err == nil {
+		residual.PortalCommit = isPortalCommit(message)
+	}
+
+	residual.RemoteBranches, err = git.RemoteBranches(portalBranch, StashBranch(portalBranch))
+
+	return
+}
+
+func isPortalCommit(message string) bool {
+	config := Meta{}
+	err := yaml.Unmarshal([]byte(message), &config)
+
+	return err == nil && config.Meta.WorkingBranch != ""
+}
@@ -0,0 +1,76 @@
+package portal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushResidualRecoveryCommands(t *testing.T) {
+	residual := Residual{
+		Push:           true,
+		WorkingBranch:  "main",
+		PortalBranch:   "tmp/portal/fp-op",
+		CurrentBranch:  "tmp/portal/fp-op",
+		PortalCommit:   true,
+		LocalChanges:   true,
+		LocalBranch:    true,
+		RemoteBranches: []string{"tmp/portal/fp-op"},
+	}
+
+	assert.Equal(t, []string{
+		"git checkout main",
+		"git reset HEAD^",
+		"git branch -D tmp/portal/fp-op",
+		"git push origin --delete tmp/portal/fp-op",
+	}, residual.RecoveryCommands())
+}
+
+func TestPushResidualRecoveryCommandsWhenWorkWasSent(t *testing.T) {
+	residual := Residual{
+		Push:           true,
+		WorkingBranch:  "main",
+		PortalBranch:   "tmp/portal/fp-op",
+		CurrentBranch:  "main",
+		RemoteBranches: []string{"tmp/portal/fp-op"},
+	}
+
+	assert.Equal(t, []string{"portal pull"}, residual.RecoveryCommands())
+	assert.Equal(t, []string{"remote branch origin/tmp/portal/fp-op exists"}, residual.State())
+}
+
+func TestPullResidualRecoveryCommands(t *testing.T) {
+	residual := Residual{
+		WorkingBranch:    "main",
+		PortalBranch:     "tmp/portal/fp-op",
+		StartingSha:      "b90012997091b1dd3f2987f6495cc9b203fed291",
+		CurrentBranch:    "HEAD",
+		RebaseInProgress: true,
+		RemoteBranches:   []string{"tmp/portal/fp-op"},
+	}
+
+	assert.Equal(t, []string{
+		"git rebase --abort",
+		"git checkout main",
+		"git reset --hard b90012997091b1dd3f2987f6495cc9b203fed291",
+		"portal pull",
+	}, residual.RecoveryCommands())
+}
+
+func TestPullResidualRecoveryCommandsWithAutostash(t *testing.T) {
+	residual := Residual{
+		WorkingBranch:  "main",
+		PortalBranch:   "tmp/portal/fp-op",
+		StartingSha:    "b90012997091b1dd3f2987f6495cc9b203fed291",
+		CurrentBranch:  "main",
+		RemoteBranches: []string{"tmp/portal/fp-op"},
+		Autostash:      "stash@{0}",
+	}
+
+	assert.Contains(t, residual.State(), "your own changes are stashed in stash@{0}")
+	assert.Equal(t, []string{
+		"git reset --hard b90012997091b1dd3f2987f6495cc9b203fed291",
+		"git stash pop --index stash@{0}",
+		"portal pull --autostash",
+	}, residual.RecoveryCommands())
+}
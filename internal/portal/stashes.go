@@ -0,0 +1,213 @@
+package portal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+type Stash struct {
+	Sha     string `yaml:"sha"`
+	Message string `yaml:"message"`
+}
+
+func StashBranch(portalBranch string) string {
+	return portalBranch + "-stash"
+}
+
+// StashEntries lists the stash from newest (stash@{0}) to oldest.
+func StashEntries() (stashes []Stash, err error) {
+	entries, err := git.StashList()
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, " ", 2)
+		stash := Stash{Sha: fields[0]}
+		if len(fields) > 1 {
+			stash.Message = fields[1]
+		}
+		stashes = append(stashes, stash)
+	}
+
+	return
+}
+
+func pushStashSteps(portalBranch string, stashes []Stash, verbose bool) []saga.Step {
+	if len(stashes) == 0 {
+		return nil
+	}
+
+	stashBranch := StashBranch(portalBranch)
+
+	commitTree := gitCommand{"commit-tree", "HEAD^{tree}", "-m", "portal stash"}
+	for _, stash := range stashes {
+		commitTree = append(commitTree, "-p", stash.Sha)
+	}
+
+	return []saga.Step{
+		networkStep(ensures(saga.Step{
+			Name: "git push portal stash",
+			Run: func(ctx context.Context) (err error) {
+				result, err := git.Run(ctx, git.Command{Args: commitTree})
+				if err != nil {
+					return
+				}
+
+				sha := strings.TrimSuffix(result.Stdout, "\n")
+				saga.StateFrom(ctx).Set(stashCommit, sha)
+
+				refspec := fmt.Sprintf("%s:refs/heads/%s", sha, stashBranch)
+				return gitCommand{"push", "origin", refspec, "--progress"}.run(ctx, verbose)
+			},
+			Undo: func(ctx context.Context) (err error) {
+				return recorded(ctx, stashCommit, verbose, func(sha string) gitCommand {
+					return deleteRemoteBranch(stashBranch, sha)
+				})
+			},
+			Abort: abortPush(stashBranch, verbose),
+			Commands: []string{
+				commitTree.String(),
+				fmt.Sprintf("git push origin <%s>:refs/heads/%s --progress", stashCommit, stashBranch),
+			},
+			UndoCommands: []string{deleteRemoteBranch(stashBranch, fmt.Sprintf("<%s>", stashCommit)).String()},
+		}, remoteBranchAt(stashBranch, stashCommit))),
+	}
+}
+
+// dropStashSteps drops the stash entries that were pushed, and only those,
+// wherever entries stashed since have moved them. They are dropped oldest
+// first so that the entries left to drop keep their index. Undone, they are
+// stored back in the same order, on top of the stash.
+func dropStashSteps(stashes []Stash, verbose bool) []saga.Step {
+	if len(stashes) == 0 {
+		return nil
+	}
+
+	drops := func(at int) (drops []gitCommand) {
+		for i := len(stashes) - 1; i >= 0; i-- {
+			drops = append(drops, gitCommand{"stash", "drop", "--quiet", fmt.Sprintf("stash@{%d}", at+i)})
+		}
+
+		return
+	}
+
+	stores := []gitCommand{}
+	for i := len(stashes) - 1; i >= 0; i-- {
+		stores = append(stores, gitCommand{"stash", "store", "-m", stashes[i].Message, stashes[i].Sha})
+	}
+
+	// dropped counts the pushed entries this step dropped, so that only those
+	// are ever stored back.
+	dropped := 0
+
+	return []saga.Step{
+		{
+			Name: "git stash drop",
+			Run: func(ctx context.Context) (err error) {
+				entries, err := StashEntries()
+				if err != nil {
+					return
+				}
+
+				at := pushedStashes(entries, stashes)
+				if at < 0 {
+					return fmt.Errorf("the stash no longer has the pushed entries %s to %s", stashes[0].Sha, stashes[len(stashes)-1].Sha)
+				}
+
+				for _, drop := range drops(at) {
+					if err = drop.run(ctx, verbose); err != nil {
+						_ = runAll(context.Background(), stores[:dropped], verbose)
+						dropped = 0
+						return
+					}
+					dropped++
+				}
+
+				return
+			},
+			Undo: func(ctx context.Context) (err error) {
+				if err = runAll(ctx, stores[:dropped], verbose); err == nil {
+					dropped = 0
+				}
+
+				return
+			},
+			Commands:     describe(drops(0)),
+			UndoCommands: describe(stores),
+		},
+	}
+}
+
+// pushedStashes is the index in entries where the pushed stashes start, in
+// the same order, or -1 when they aren't all there.
+func pushedStashes(entries []Stash, stashes []Stash) int {
+	for at := range entries {
+		if len(entries)-at < len(stashes) {
+			break
+		}
+
+		if entries[at].Sha == stashes[0].Sha {
+			for i, stash := range stashes {
+				if entries[at+i].Sha != stash.Sha {
+					return -1
+				}
+			}
+
+			return at
+		}
+	}
+
+	return -1
+}
+
+func pullStashSteps(portalBranch string, stashes []Stash, verbose bool) ([]saga.Step, error) {
+	if len(stashes) == 0 {
+		return nil, nil
+	}
+
+	stashBranch := StashBranch(portalBranch)
+
+	stores := []gitCommand{}
+	drops := []gitCommand{}
+	for i := len(stashes) - 1; i >= 0; i-- {
+		stores = append(stores, gitCommand{"stash", "store", "-m", stashes[i].Message, stashes[i].Sha})
+		drops = append(drops, gitCommand{"stash", "drop", "--quiet"})
+	}
+
+	// stored counts the entries this step added on top of the puller's own
+	// stash, so that only those are ever dropped.
+	stored := 0
+
+	return []saga.Step{
+		{
+			Name: "git stash store",
+			Run: func(ctx context.Context) (err error) {
+				for _, store := range stores {
+					if err = store.run(ctx, verbose); err != nil {
+						_ = runAll(context.Background(), drops[:stored], verbose)
+						stored = 0
+						return
+					}
+					stored++
+				}
+
+				return
+			},
+			Undo: func(ctx context.Context) (err error) {
+				if err = runAll(ctx, drops[:stored], verbose); err == nil {
+					stored = 0
+				}
+
+				return
+			},
+			Commands:     describe(stores),
+			UndoCommands: describe(drops),
+		},
+		deleteRemoteStep(verbose, "delete remote portal stash branch", stashBranch, remoteStashCommit),
+	}, nil
+}
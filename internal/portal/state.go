@@ -0,0 +1,118 @@
+package portal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+const (
+	// workingCommit is where the working branch was before the portal
+	// commit was made on top of it.
+	workingCommit saga.Key = "workingCommitSha"
+
+	// workingIndex is the tree of the pusher's index before push staged
+	// everything, for push --keep to put back.
+	workingIndex saga.Key = "workingIndexTree"
+
+	// portalCommit is the portal work in progress commit, made on push and
+	// rebased onto on pull.
+	portalCommit saga.Key = "portalCommitSha"
+
+	// startingCommit is where the working branch was before pull, and
+	// baseCommit where the portal commit was rebased onto, in place of
+	// pusherBaseCommit, the sha the pusher based it on.
+	startingCommit   saga.Key = "startingCommitSha"
+	baseCommit       saga.Key = "baseCommitSha"
+	pusherBaseCommit saga.Key = "pusherBaseCommitSha"
+
+	// ontoBranch is the branch pull --onto applied the portal work on, and
+	// createdBranch that branch when pull created it.
+	ontoBranch    saga.Key = "ontoBranch"
+	createdBranch saga.Key = "createdBranch"
+
+	// autostashCommit is the stash of the puller's own changes, and
+	// autostashConflicts the files, one per line, whose changes conflicted
+	// when it was applied again.
+	autostashCommit    saga.Key = "autostashCommitSha"
+	autostashConflicts saga.Key = "autostashConflicts"
+
+	// stashCommit is the commit whose parents are the carried stashes.
+	stashCommit saga.Key = "stashCommitSha"
+
+	// remotePortalCommit and remoteStashCommit are where origin's portal
+	// branches were before pull deleted them.
+	remotePortalCommit saga.Key = "remotePortalCommitSha"
+	remoteStashCommit  saga.Key = "remoteStashCommitSha"
+)
+
+// record saves the sha ref points at under key.
+func record(ctx context.Context, key saga.Key, ref string) error {
+	sha, err := git.RevParse(ref)
+	if err != nil {
+		return err
+	}
+
+	saga.StateFrom(ctx).Set(key, sha)
+
+	return nil
+}
+
+// lookup is the value recorded under key, if there is one.
+func lookup(state *saga.State, key saga.Key) (string, bool) {
+	value, err := state.Get(key)
+
+	return value, err == nil
+}
+
+// recording makes step record the sha ref points at under key once it has
+// run, or been resumed.
+func recording(step saga.Step, key saga.Key, ref string) saga.Step {
+	step.Run = recordingAfter(step.Run, key, ref)
+	if step.Resume != nil {
+		step.Resume = recordingAfter(step.Resume, key, ref)
+	}
+
+	return step
+}
+
+func recordingAfter(fn func(ctx context.Context) error, key saga.Key, ref string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+
+		return record(ctx, key, ref)
+	}
+}
+
+// recordingIndex makes step record the tree of the index under
+// workingIndex before it runs.
+func recordingIndex(step saga.Step) saga.Step {
+	run := step.Run
+	step.Run = func(ctx context.Context) error {
+		tree, err := git.WriteTree()
+		if err != nil {
+			return err
+		}
+
+		saga.StateFrom(ctx).Set(workingIndex, tree)
+
+		return run(ctx)
+	}
+	step.Commands = append([]string{fmt.Sprintf("git write-tree > <%s>", workingIndex)}, step.Commands...)
+
+	return step
+}
+
+// recorded runs the command built from the sha saved under key.
+func recorded(ctx context.Context, key saga.Key, verbose bool, command func(sha string) gitCommand) error {
+	sha, err := saga.StateFrom(ctx).Get(key)
+	if err != nil {
+		return err
+	}
+
+	return command(sha).run(ctx, verbose)
+}
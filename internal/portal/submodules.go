@@ -0,0 +1,186 @@
+package portal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+type Submodule struct {
+	Path     string `yaml:"path"`
+	Sha      string `yaml:"sha"`
+	Index    string `yaml:"index,omitempty"`
+	Worktree string `yaml:"worktree,omitempty"`
+}
+
+func SnapshotSubmodules() (submodules []Submodule, err error) {
+	if submodules, err = DirtySubmodules(); err != nil || len(submodules) == 0 {
+		return
+	}
+
+	topLevel, err := git.TopLevel()
+	if err != nil {
+		return
+	}
+
+	for i, submodule := range submodules {
+		index, err := git.SubmoduleIndexPatch(topLevel, submodule.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		worktree, err := git.SubmoduleWorktreePatch(topLevel, submodule.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		submodules[i].Index = base64.StdEncoding.EncodeToString([]byte(index))
+		submodules[i].Worktree = base64.StdEncoding.EncodeToString([]byte(worktree))
+	}
+
+	return
+}
+
+// DirtySubmodules checks that the dirty submodules can be carried, without
+// the patches of their changes: diffing a worktree stages it into a
+// throwaway index, which writes its files to the submodule's objects.
+func DirtySubmodules() (submodules []Submodule, err error) {
+	paths, err := git.DirtySubmodules()
+	if err != nil || len(paths) == 0 {
+		return
+	}
+
+	if !git.RecurseSubmodules() {
+		return nil, fmt.Errorf("dirty submodules %s cannot be carried while portal.recurseSubmodules is false", strings.Join(paths, ", "))
+	}
+
+	topLevel, err := git.TopLevel()
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		sha, err := git.SubmoduleHead(topLevel, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !git.SubmodulePublished(topLevel, path, sha) {
+			return nil, fmt.Errorf("submodule %s is on unpublished commit %s, push it first", path, sha)
+		}
+
+		submodules = append(submodules, Submodule{Path: path, Sha: sha})
+	}
+
+	return
+}
+
+// clearSubmoduleSteps throw the submodules' changes away and put each back
+// on the commit its superproject records, parents first so that a nested
+// submodule goes where its parent records once cleared.
+func clearSubmoduleSteps(submodules []Submodule, verbose bool) (steps []saga.Step, err error) {
+	topLevel, err := git.TopLevel()
+	if err != nil {
+		return
+	}
+
+	for _, submodule := range submodules {
+		path := filepath.Join(topLevel, submodule.Path)
+		superproject, inside := superprojectOf(submodules, submodule.Path)
+		clear := []gitCommand{{"-C", path, "reset", "--hard", "--quiet"}, {"-C", path, "clean", "-fd", "--quiet"}}
+
+		steps = append(steps, saga.Step{
+			Name: fmt.Sprintf("clear submodule %s", submodule.Path),
+			Run: func(ctx context.Context) error {
+				if err := runAll(ctx, clear, verbose); err != nil {
+					return err
+				}
+
+				gitlink, err := git.Gitlink(filepath.Join(topLevel, superproject), inside)
+				if err != nil {
+					return err
+				}
+
+				return gitCommand{"-C", path, "checkout", "--quiet", "--detach", gitlink}.run(ctx, verbose)
+			},
+			Commands: append(describe(clear), fmt.Sprintf("git -C %s checkout --quiet --detach <gitlink>", quote(path))),
+		})
+	}
+
+	return
+}
+
+// superprojectOf is the innermost of submodules that the submodule at path
+// is nested in, the top level when none, and path inside it.
+func superprojectOf(submodules []Submodule, path string) (superproject string, inside string) {
+	inside = path
+	for _, submodule := range submodules {
+		prefix := submodule.Path + "/"
+		if strings.HasPrefix(path, prefix) && len(submodule.Path) > len(superproject) {
+			superproject, inside = submodule.Path, strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	return
+}
+
+func restoreSubmoduleSteps(submodules []Submodule, verbose bool) (steps []saga.Step, err error) {
+	topLevel, err := git.TopLevel()
+	if err != nil {
+		return
+	}
+
+	for _, submodule := range submodules {
+		path := filepath.Join(topLevel, submodule.Path)
+
+		index, err := base64.StdEncoding.DecodeString(submodule.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		worktree, err := base64.StdEncoding.DecodeString(submodule.Worktree)
+		if err != nil {
+			return nil, err
+		}
+
+		applyWorktree := gitCommand{"-C", path, "apply", "--binary"}
+		applyIndex := gitCommand{"-C", path, "apply", "--binary", "--cached"}
+
+		steps = append(steps, []saga.Step{
+			networkStep(commandStep(verbose, fmt.Sprintf("git checkout submodule %s", submodule.Path),
+				[]gitCommand{{"-C", path, "fetch", "--quiet"}, {"-C", path, "checkout", "--quiet", "--detach", submodule.Sha}},
+				[]gitCommand{{"-C", path, "checkout", "--quiet", "--force", "-"}, {"-C", path, "clean", "-fd", "--quiet"}})),
+			{
+				Name: fmt.Sprintf("git apply submodule %s changes", submodule.Path),
+				Run: func(ctx context.Context) (err error) {
+					if err = applyPatch(ctx, applyWorktree, worktree, verbose); err != nil {
+						return
+					}
+
+					return applyPatch(ctx, applyIndex, index, verbose)
+				},
+				Commands: []string{
+					applyWorktree.String() + " < <worktree patch>",
+					applyIndex.String() + " < <index patch>",
+				},
+			},
+		}...)
+	}
+
+	return
+}
+
+func applyPatch(ctx context.Context, apply gitCommand, patch []byte, verbose bool) error {
+	if len(patch) == 0 {
+		return nil
+	}
+
+	_, err := git.Run(ctx, git.Command{Args: apply, Stdin: string(patch), Verbose: verbose})
+
+	return err
+}
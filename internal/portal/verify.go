@@ -0,0 +1,57 @@
+package portal
+
+import (
+	"strings"
+
+	"github.com/ericTsiliacos/portal/internal/git"
+)
+
+type Verification struct {
+	Tree []string
+}
+
+// Matches reports whether the worktree is what the pusher sent.
+func (v Verification) Matches() bool {
+	return len(v.Tree) == 0
+}
+
+// Without leaves out differences in paths, such as the puller's own changes
+// applied again after the pull.
+func (v Verification) Without(paths []string) Verification {
+	return Verification{Tree: withoutPaths(v.Tree, paths)}
+}
+
+func withoutPaths(differences []string, paths []string) (kept []string) {
+	excluded := map[string]bool{}
+	for _, path := range paths {
+		excluded[path] = true
+	}
+
+	for _, difference := range differences {
+		fields := strings.SplitN(difference, "\t", 2)
+		if len(fields) == 2 && excluded[fields[1]] {
+			continue
+		}
+		kept = append(kept, difference)
+	}
+
+	return
+}
+
+// Verify compares the worktree with the pusher's. The pusher's index isn't:
+// a pull leaves the work unstaged, and the pusher's index tree never leaves
+// their repository.
+func Verify(config Meta) (verification Verification, err error) {
+	if config.Meta.Tree != "" {
+		tree, err := git.WorktreeTree(config.Meta.IgnoredFiles)
+		if err != nil {
+			return verification, err
+		}
+
+		if verification.Tree, err = git.DiffTrees(config.Meta.Tree, tree); err != nil {
+			return verification, err
+		}
+	}
+
+	return
+}
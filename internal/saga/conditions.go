@@ -0,0 +1,44 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+)
+
+// Condition is something a step expects to hold. Check returns how it
+// doesn't, or nil when it does.
+type Condition struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// ConditionError is a condition that didn't hold before or after a step ran.
+type ConditionError struct {
+	Step      string
+	Condition string
+	Post      bool
+	Err       error
+}
+
+func (e *ConditionError) Error() string {
+	when := "before"
+	if e.Post {
+		when = "after"
+	}
+
+	return fmt.Sprintf("expected %s %s %s: %v", e.Condition, when, e.Step, e.Err)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+func check(ctx context.Context, step Step, conditions []Condition, post bool) error {
+	for _, condition := range conditions {
+		if err := condition.Check(ctx); err != nil {
+			return &ConditionError{Step: step.Name, Condition: condition.Name, Post: post, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Error reports the step that failed a Run and how far compensation got.
+type Error struct {
+	Step     string
+	Err      error
+	Output   string
+	Undone   []string
+	UndoErrs UndoErrors
+}
+
+func (e *Error) Error() string {
+	if len(e.UndoErrs) > 0 {
+		return fmt.Sprintf("%s: %v (%v)", e.Step, e.Err, e.UndoErrs)
+	}
+
+	return fmt.Sprintf("%s: %v", e.Step, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Cancelled reports whether the Run stopped because its context was
+// cancelled.
+func (e *Error) Cancelled() bool {
+	return errors.Is(e.Err, context.Canceled)
+}
+
+// RolledBack reports whether every completed step was compensated, leaving
+// the repository as it was before the Run.
+func (e *Error) RolledBack() bool {
+	return len(e.UndoErrs) == 0
+}
+
+// UndoError reports a compensation that failed.
+type UndoError struct {
+	Step   string
+	Err    error
+	Output string
+}
+
+func (e *UndoError) Error() string {
+	return fmt.Sprintf("undo %s: %v", e.Step, e.Err)
+}
+
+func (e *UndoError) Unwrap() error {
+	return e.Err
+}
+
+// UndoErrors collects every compensation that failed during a rollback.
+type UndoErrors []*UndoError
+
+func (e UndoErrors) Error() string {
+	messages := []string{}
+	for _, undoError := range e {
+		messages = append(messages, undoError.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+type outputError interface {
+	CommandOutput() string
+}
+
+func commandOutput(err error) string {
+	var output outputError
+	if errors.As(err, &output) {
+		return output.CommandOutput()
+	}
+
+	return ""
+}
+
+// Pause makes a step stop the run where it is, without compensating, so that
+// someone can step in. err says why.
+func Pause(err error) error {
+	return &pauseError{err: err}
+}
+
+type pauseError struct {
+	err error
+}
+
+func (e *pauseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pauseError) Unwrap() error {
+	return e.err
+}
+
+// PausedError reports a run stopped by a step that paused.
+type PausedError struct {
+	Step string
+	Err  error
+}
+
+func (e *PausedError) Error() string {
+	return fmt.Sprintf("paused at %s: %v", e.Step, e.Err)
+}
+
+func (e *PausedError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is a step that ran out of time, either its own Timeout or
+// the deadline of the whole Run.
+type TimeoutError struct {
+	Step  string
+	After time.Duration
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v in step %s", e.After, e.Step)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// cancelledError is a step failure caused by its context being cancelled. It
+// is both, so errors.Is finds context.Canceled while the command output of
+// the killed step is kept.
+type cancelledError struct {
+	cancel error
+	err    error
+}
+
+func (e *cancelledError) Error() string {
+	return e.cancel.Error()
+}
+
+func (e *cancelledError) Unwrap() error {
+	return e.err
+}
+
+func (e *cancelledError) Is(target error) bool {
+	return errors.Is(e.cancel, target)
+}
@@ -0,0 +1,56 @@
+package saga
+
+import "time"
+
+type EventKind int
+
+const (
+	StepStarted EventKind = iota
+	StepSucceeded
+	StepFailed
+	UndoStarted
+	UndoFinished
+	StepPaused
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case StepStarted:
+		return "started"
+	case StepSucceeded:
+		return "succeeded"
+	case StepFailed:
+		return "failed"
+	case UndoStarted:
+		return "undo started"
+	case UndoFinished:
+		return "undo finished"
+	case StepPaused:
+		return "paused"
+	}
+
+	return "unknown"
+}
+
+// Event reports progress of a step. Elapsed is only set once the step, or
+// its undo, has finished; Err is set when it failed.
+type Event struct {
+	Kind    EventKind
+	Step    string
+	Elapsed time.Duration
+	Err     error
+}
+
+// Observer is notified of every event, synchronously, as the saga runs.
+type Observer func(Event)
+
+// Observe adds an observer to the saga.
+func (s *Saga) Observe(observer Observer) {
+	s.observers = append(s.observers, observer)
+}
+
+func (s *Saga) notify(event Event) {
+	for _, observer := range s.observers {
+		observer(event)
+	}
+}
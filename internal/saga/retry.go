@@ -0,0 +1,47 @@
+package saga
+
+import (
+	"context"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/logger"
+)
+
+// Retry is how often a step is attempted before its failure counts. The zero
+// value attempts a step once.
+type Retry struct {
+	Attempts int
+
+	// Backoff is the wait before the second attempt, doubled after each
+	// attempt that follows.
+	Backoff time.Duration
+
+	// Retryable decides whether an error is worth another attempt. Without
+	// it every error is.
+	Retryable func(error) bool
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't retryable,
+// runs out of attempts or ctx is done, and returns the last error.
+func (r Retry) Do(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	backoff := r.Backoff
+
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil {
+			return
+		}
+
+		if attempt >= r.Attempts || (r.Retryable != nil && !r.Retryable(err)) {
+			return
+		}
+
+		logger.LogInfo.Printf("%s: attempt %d of %d failed, retrying in %v: %v", name, attempt, r.Attempts, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
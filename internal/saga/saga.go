@@ -1,41 +1,233 @@
 package saga
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
 type Saga struct {
-	steps []Step
+	steps     []Step
+	completed int
+	paused    bool
+	observers []Observer
+	state     *State
 }
 
 type Step struct {
 	Name string
-	Run  func() error
-	Undo func() error
+	Run  func(ctx context.Context) error
+	Undo func(ctx context.Context) error
+
+	// Abort cleans up after Run was interrupted halfway by cancellation,
+	// e.g. a rebase left in progress. Undo is only for steps that completed.
+	Abort func(ctx context.Context) error
+
+	// Resume finishes the step after Run returned Pause, e.g. continues a
+	// rebase once its conflicts are resolved. It may pause again.
+	Resume func(ctx context.Context) error
+
+	// Commands and UndoCommands describe what Run and Undo execute, so a
+	// saga can be planned without running it.
+	Commands     []string
+	UndoCommands []string
+
+	// Retry applies to both Run and Undo.
+	Retry Retry
+
+	// Timeout bounds Run, retries included. Zero means no limit.
+	Timeout time.Duration
+
+	// Pre must hold for Run to start and Post must hold once it's done,
+	// a git command exiting 0 doesn't always mean it did what was meant.
+	Pre  []Condition
+	Post []Condition
 }
 
 func New(steps []Step) Saga {
-	return Saga{steps: steps}
+	return Saga{steps: steps, state: &State{}}
 }
 
-func (s *Saga) Run() (errors []string) {
-	for i, step := range s.steps {
+func (s *Saga) Steps() []Step {
+	return s.steps
+}
 
-		if err := step.Run(); err != nil {
-			errors = append(errors, err.Error())
-			if latestError := undo(reverseSteps(s.steps[0:i])); latestError != nil {
-				return append(errors, latestError.Error())
-			} else {
-				return errors
+// State is what the steps have recorded. Steps reach it through their
+// context with StateFrom.
+func (s *Saga) State() *State {
+	return s.state
+}
+
+// Run executes every step in order. When a step fails, the steps before it
+// are compensated and an *Error describing the failure is returned.
+//
+// Cancelling ctx, or reaching its deadline, stops Run before the next step.
+// A step interrupted that way, by its own Timeout or that broke one of its
+// Post conditions, is aborted before the steps before it are compensated.
+// Compensations get a fresh context, they must run to completion.
+//
+// A step that returns Pause stops Run without compensating anything, and a
+// *PausedError is returned. The run is then either picked up with Resume or
+// given up with Abort.
+func (s *Saga) Run(ctx context.Context) error {
+	return s.runFrom(ctx, 0, false)
+}
+
+// Resume continues a paused run: the paused step is finished with its
+// Resume and the steps after it run. It fails like Run, except that a
+// failure to resume also aborts the paused step.
+func (s *Saga) Resume(ctx context.Context) error {
+	if !s.paused {
+		return errors.New("the saga is not paused")
+	}
+
+	return s.runFrom(ctx, s.completed, true)
+}
+
+// Abort gives up a paused run, aborting the paused step and compensating
+// every step before it.
+func (s *Saga) Abort(ctx context.Context) error {
+	if !s.paused {
+		return errors.New("the saga is not paused")
+	}
+
+	compensate := append(s.steps[0:s.completed:s.completed], aborted(s.steps[s.completed]))
+	if _, errs := s.undo(withState(ctx, s.state), reverseSteps(compensate)); errs != nil {
+		return errs
+	}
+
+	s.completed = 0
+	s.paused = false
+
+	return nil
+}
+
+func (s *Saga) runFrom(ctx context.Context, from int, resume bool) error {
+	limit := timeLimit(ctx)
+	ctx = withState(ctx, s.state)
+
+	for i := from; i < len(s.steps); i++ {
+		step := s.steps[i]
+		resuming := resume && i == from
+
+		if ctx.Err() != nil {
+			compensate := s.steps[0:i]
+			if resuming {
+				compensate = append(s.steps[0:i:i], aborted(step))
+			}
+
+			return s.fail(step, interruption(ctx, step.Name, limit, nil), compensate)
+		}
+
+		s.notify(Event{Kind: StepStarted, Step: step.Name})
+		start := time.Now()
+
+		run := runStep
+		if resuming {
+			run = resumeStep
+		}
+
+		if err := run(ctx, step); err != nil {
+			var pause *pauseError
+			if errors.As(err, &pause) {
+				s.notify(Event{Kind: StepPaused, Step: step.Name, Elapsed: time.Since(start), Err: pause.err})
+				s.completed = i
+				s.paused = true
+
+				return &PausedError{Step: step.Name, Err: pause.err}
+			}
+
+			s.notify(Event{Kind: StepFailed, Step: step.Name, Elapsed: time.Since(start), Err: err})
+			s.paused = false
+
+			var timeout *TimeoutError
+			var condition *ConditionError
+			switch {
+			case ctx.Err() != nil:
+				return s.fail(step, interruption(ctx, step.Name, limit, err), append(s.steps[0:i:i], aborted(step)))
+			case resuming, errors.As(err, &timeout), errors.As(err, &condition) && condition.Post:
+				return s.fail(step, err, append(s.steps[0:i:i], aborted(step)))
 			}
+
+			return s.fail(step, err, s.steps[0:i])
 		}
 
+		s.notify(Event{Kind: StepSucceeded, Step: step.Name, Elapsed: time.Since(start)})
+		s.completed = i + 1
+		s.paused = false
 	}
 
-	return errors
+	return nil
+}
+
+// Checkpoint is where a paused run stopped, so that a later process can
+// Restore it onto the same steps and Resume or Abort.
+type Checkpoint struct {
+	Paused int            `json:"paused"`
+	State  map[Key]string `json:"state"`
+}
+
+func (s *Saga) Checkpoint() Checkpoint {
+	return Checkpoint{Paused: s.completed, State: s.state.Values()}
 }
 
-func undo(undoSteps []Step) (err error) {
+func (s *Saga) Restore(checkpoint Checkpoint) error {
+	if checkpoint.Paused < 0 || checkpoint.Paused >= len(s.steps) {
+		return fmt.Errorf("the run was paused at step %d of %d", checkpoint.Paused+1, len(s.steps))
+	}
+
+	for key, value := range checkpoint.State {
+		s.state.Set(key, value)
+	}
+
+	s.completed = checkpoint.Paused
+	s.paused = true
+
+	return nil
+}
+
+// Undo compensates every step completed by the last Run, latest first.
+func (s *Saga) Undo(ctx context.Context) error {
+	if _, errs := s.undo(withState(ctx, s.state), reverseSteps(s.steps[0:s.completed])); errs != nil {
+		return errs
+	}
+
+	s.completed = 0
+
+	return nil
+}
+
+func (s *Saga) fail(step Step, err error, compensate []Step) error {
+	undone, undoErrs := s.undo(withState(context.Background(), s.state), reverseSteps(compensate))
+
+	return &Error{
+		Step:     step.Name,
+		Err:      err,
+		Output:   commandOutput(err),
+		Undone:   undone,
+		UndoErrs: undoErrs,
+	}
+}
+
+// undo runs every compensation even when earlier ones fail, so that as
+// little as possible is left behind.
+func (s *Saga) undo(ctx context.Context, undoSteps []Step) (undone []string, errs UndoErrors) {
+	ctx = context.WithValue(ctx, compensatingKey{}, true)
+
 	for _, undoStep := range undoSteps {
 		if undoStep.Undo != nil {
-			if err = undoStep.Undo(); err != nil {
-				return
+			s.notify(Event{Kind: UndoStarted, Step: undoStep.Name})
+			start := time.Now()
+
+			undoErr := undoStep.Retry.Do(ctx, "undo "+undoStep.Name, undoStep.Undo)
+
+			s.notify(Event{Kind: UndoFinished, Step: undoStep.Name, Elapsed: time.Since(start), Err: undoErr})
+
+			if undoErr != nil {
+				errs = append(errs, &UndoError{Step: undoStep.Name, Err: undoErr, Output: commandOutput(undoErr)})
+			} else {
+				undone = append(undone, undoStep.Name)
 			}
 		}
 	}
@@ -43,9 +235,86 @@ func undo(undoSteps []Step) (err error) {
 	return
 }
 
+func runStep(ctx context.Context, step Step) error {
+	if err := check(ctx, step, step.Pre, false); err != nil {
+		return err
+	}
+
+	stepCtx := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	err := step.Retry.Do(stepCtx, step.Name, step.Run)
+	if err != nil && ctx.Err() == nil && stepCtx.Err() != nil {
+		return &TimeoutError{Step: step.Name, After: step.Timeout, Err: err}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return check(ctx, step, step.Post, true)
+}
+
+// resumeStep finishes a paused step, which has to hold its Post conditions
+// as if it had run in one go.
+func resumeStep(ctx context.Context, step Step) error {
+	if step.Resume == nil {
+		return fmt.Errorf("%s can't be resumed", step.Name)
+	}
+
+	if err := step.Resume(ctx); err != nil {
+		return err
+	}
+
+	return check(ctx, step, step.Post, true)
+}
+
+// interruption is why ctx stopped the step: its deadline or a cancellation.
+func interruption(ctx context.Context, step string, limit time.Duration, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Step: step, After: limit, Err: err}
+	}
+
+	return &cancelledError{cancel: ctx.Err(), err: err}
+}
+
+type timeoutKey struct{}
+
+// WithTimeout is a context for a Run that times out after timeout, the
+// TimeoutError of which says so even when the saga was resumed part way.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(ctx, timeoutKey{}, timeout), timeout)
+}
+
+// timeLimit is how long ctx allows the whole Run to take: the timeout it was
+// made with by WithTimeout, otherwise the time left until its deadline.
+func timeLimit(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline).Round(time.Second)
+	}
+
+	return 0
+}
+
+// aborted compensates an interrupted step with its Abort.
+func aborted(step Step) Step {
+	step.Undo = step.Abort
+
+	return step
+}
+
 func reverseSteps(steps []Step) []Step {
-	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
-		steps[i], steps[j] = steps[j], steps[i]
+	reversed := make([]Step, len(steps))
+	for i, step := range steps {
+		reversed[len(steps)-1-i] = step
 	}
-	return steps
+	return reversed
 }
@@ -1,8 +1,11 @@
 package saga
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,20 +15,20 @@ func TestSagaMultipleSuccessfulSteps(t *testing.T) {
 	steps := []Step{
 		{
 			Name: "addOne",
-			Run:  func() (err error) { globalState = globalState + 1; return },
-			Undo: func() (err error) { globalState = globalState - 1; return },
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
 		},
 		{
 			Name: "addOne",
-			Run:  func() (err error) { globalState = globalState + 1; return },
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
 		},
 	}
 
 	saga := New(steps)
-	errs := saga.Run()
+	err := saga.Run(context.TODO())
 
 	assert.Equal(t, globalState, 2)
-	assert.Empty(t, errs)
+	assert.NoError(t, err)
 }
 
 func TestSagaWithFailure(t *testing.T) {
@@ -33,25 +36,31 @@ func TestSagaWithFailure(t *testing.T) {
 	steps := []Step{
 		{
 			Name: "addOne",
-			Run:  func() (err error) { globalState = globalState + 1; return },
-			Undo: func() (err error) { globalState = globalState - 1; return },
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
 		},
 		{
 			Name: "addTwo",
-			Run:  func() (err error) { globalState = globalState + 2; return },
-			Undo: func() (err error) { globalState = globalState - 2; return },
+			Run:  func(context.Context) (err error) { globalState = globalState + 2; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 2; return },
 		},
 		{
 			Name: "boom!",
-			Run:  func() (err error) { return errors.New("uh oh!") },
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
 		},
 	}
 
 	saga := New(steps)
-	errs := saga.Run()
+	err := saga.Run(context.TODO())
 
 	assert.Equal(t, globalState, 0)
-	assert.Equal(t, errs, []string{"uh oh!"})
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, "boom!", sagaError.Step)
+	assert.EqualError(t, sagaError.Err, "uh oh!")
+	assert.Equal(t, []string{"addTwo", "addOne"}, sagaError.Undone)
+	assert.True(t, sagaError.RolledBack())
 }
 
 func TestSagaWithMissingUndo(t *testing.T) {
@@ -59,27 +68,31 @@ func TestSagaWithMissingUndo(t *testing.T) {
 	steps := []Step{
 		{
 			Name: "addOne",
-			Run:  func() (err error) { globalState = globalState + 1; return },
-			Undo: func() (err error) {
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) {
 				globalState = globalState - 1
 				return
 			},
 		},
 		{
 			Name: "addTwo",
-			Run:  func() (err error) { globalState = globalState + 2; return },
+			Run:  func(context.Context) (err error) { globalState = globalState + 2; return },
 		},
 		{
 			Name: "boom!",
-			Run:  func() (err error) { return errors.New("uh oh!") },
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
 		},
 	}
 
 	saga := New(steps)
-	errs := saga.Run()
+	err := saga.Run(context.TODO())
 
 	assert.Equal(t, globalState, 2)
-	assert.Equal(t, errs, []string{"uh oh!"})
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, []string{"addOne"}, sagaError.Undone)
+	assert.True(t, sagaError.RolledBack())
 }
 
 func TestSagaWithUndoFailure(t *testing.T) {
@@ -87,20 +100,586 @@ func TestSagaWithUndoFailure(t *testing.T) {
 	steps := []Step{
 		{
 			Name: "addOne",
-			Run:  func() (err error) { globalState = globalState + 1; return },
-			Undo: func() (err error) {
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) {
 				return errors.New("recovery error")
 			},
 		},
 		{
 			Name: "boom!",
-			Run:  func() (err error) { return errors.New("uh oh!") },
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
 		},
 	}
 
 	saga := New(steps)
-	errs := saga.Run()
+	err := saga.Run(context.TODO())
 
 	assert.Equal(t, globalState, 1)
-	assert.Equal(t, errs, []string{"uh oh!", "recovery error"})
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.EqualError(t, sagaError.Err, "uh oh!")
+	assert.Empty(t, sagaError.Undone)
+	assert.False(t, sagaError.RolledBack())
+	assert.Len(t, sagaError.UndoErrs, 1)
+	assert.Equal(t, "addOne", sagaError.UndoErrs[0].Step)
+	assert.EqualError(t, sagaError.UndoErrs[0].Err, "recovery error")
+}
+
+func TestSagaContinuesUndoAfterUndoFailure(t *testing.T) {
+	globalState := 0
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
+		},
+		{
+			Name: "addTwo",
+			Run:  func(context.Context) (err error) { globalState = globalState + 2; return },
+			Undo: func(context.Context) (err error) { return errors.New("recovery error") },
+		},
+		{
+			Name: "addThree",
+			Run:  func(context.Context) (err error) { globalState = globalState + 3; return },
+			Undo: func(context.Context) (err error) { return errors.New("another recovery error") },
+		},
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	assert.Equal(t, globalState, 5)
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, []string{"addOne"}, sagaError.Undone)
+	assert.False(t, sagaError.RolledBack())
+	assert.EqualError(t, sagaError.UndoErrs, "undo addThree: another recovery error; undo addTwo: recovery error")
+}
+
+func TestSagaErrorUnwrapsStepError(t *testing.T) {
+	stepError := errors.New("uh oh!")
+	steps := []Step{
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return fmt.Errorf("wrapped: %w", stepError) },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	assert.True(t, errors.Is(err, stepError))
+	assert.EqualError(t, err, "boom!: wrapped: uh oh!")
+}
+
+func TestSagaErrorCapturesCommandOutput(t *testing.T) {
+	steps := []Step{
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return outputFailure{output: "fatal: not a git repository"} },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, "fatal: not a git repository", sagaError.Output)
+}
+
+func TestSagaRetriesStep(t *testing.T) {
+	attempts := 0
+	steps := []Step{
+		{
+			Name: "flaky",
+			Run: func(context.Context) (err error) {
+				attempts++
+				if attempts < 3 {
+					return errors.New("connection reset")
+				}
+				return
+			},
+			Retry: Retry{Attempts: 3},
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestSagaRetryGivesUpOnPermanentFailure(t *testing.T) {
+	attempts := 0
+	undos := 0
+	permanent := errors.New("rejected")
+	retry := Retry{
+		Attempts:  3,
+		Retryable: func(err error) bool { return err != permanent },
+	}
+	steps := []Step{
+		{
+			Name: "undo retried",
+			Run:  func(context.Context) (err error) { return },
+			Undo: func(context.Context) (err error) {
+				undos++
+				if undos == 1 {
+					return errors.New("timeout")
+				}
+				return
+			},
+			Retry: retry,
+		},
+		{
+			Name:  "rejected",
+			Run:   func(context.Context) (err error) { attempts++; return permanent },
+			Retry: retry,
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, 2, undos)
+	assert.True(t, sagaError.RolledBack())
+}
+
+func TestSagaNotifiesObservers(t *testing.T) {
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { return },
+			Undo: func(context.Context) (err error) { return },
+		},
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
+		},
+	}
+
+	events := []string{}
+	saga := New(steps)
+	saga.Observe(func(event Event) {
+		events = append(events, fmt.Sprintf("%s %s", event.Step, event.Kind))
+	})
+	_ = saga.Run(context.TODO())
+
+	assert.Equal(t, []string{
+		"addOne started",
+		"addOne succeeded",
+		"boom! started",
+		"boom! failed",
+		"addOne undo started",
+		"addOne undo finished",
+	}, events)
+}
+
+func TestSagaCancelledDuringStep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	compensations := []string{}
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { return },
+			Undo: func(ctx context.Context) (err error) {
+				assert.NoError(t, ctx.Err())
+				compensations = append(compensations, "undo addOne")
+				return
+			},
+		},
+		{
+			Name: "rebase",
+			Run: func(ctx context.Context) (err error) {
+				cancel()
+				return errors.New("signal: killed")
+			},
+			Undo: func(context.Context) (err error) {
+				compensations = append(compensations, "undo rebase")
+				return
+			},
+			Abort: func(context.Context) (err error) {
+				compensations = append(compensations, "abort rebase")
+				return
+			},
+		},
+		{
+			Name: "never",
+			Run:  func(context.Context) (err error) { t.Fail(); return },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(ctx)
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, sagaError.Cancelled())
+	assert.Equal(t, "rebase", sagaError.Step)
+	assert.Equal(t, []string{"abort rebase", "undo addOne"}, compensations)
+}
+
+func TestSagaCancelledBetweenSteps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	globalState := 0
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; cancel(); return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
+		},
+		{
+			Name: "addTwo",
+			Run:  func(context.Context) (err error) { globalState = globalState + 2; return },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(ctx)
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.True(t, sagaError.Cancelled())
+	assert.Equal(t, "addTwo", sagaError.Step)
+	assert.Equal(t, 0, globalState)
+}
+
+func TestSagaStepTimeout(t *testing.T) {
+	globalState := 0
+	aborted := false
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
+		},
+		{
+			Name:    "hang",
+			Run:     func(ctx context.Context) error { <-ctx.Done(); return errors.New("signal: killed") },
+			Abort:   func(context.Context) (err error) { aborted = true; return },
+			Timeout: 10 * time.Millisecond,
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	var sagaError *Error
+	var timeoutError *TimeoutError
+	assert.True(t, errors.As(err, &sagaError))
+	assert.True(t, errors.As(err, &timeoutError))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, sagaError.Cancelled())
+	assert.EqualError(t, timeoutError, "timed out after 10ms in step hang")
+	assert.True(t, aborted)
+	assert.Equal(t, 0, globalState)
+}
+
+func TestSagaDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	steps := []Step{
+		{
+			Name: "hang",
+			Run:  func(ctx context.Context) error { <-ctx.Done(); return errors.New("signal: killed") },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(ctx)
+
+	var timeoutError *TimeoutError
+	assert.True(t, errors.As(err, &timeoutError))
+	assert.EqualError(t, timeoutError, "timed out after 1s in step hang")
+}
+
+func TestSagaDeadlineAfterResume(t *testing.T) {
+	steps := []Step{
+		{
+			Name:   "conflict",
+			Run:    func(context.Context) error { return Pause(errors.New("conflicts")) },
+			Resume: func(context.Context) error { return nil },
+		},
+		{
+			Name: "hang",
+			Run:  func(ctx context.Context) error { <-ctx.Done(); return errors.New("signal: killed") },
+		},
+	}
+
+	saga := New(steps)
+	_, paused := saga.Run(context.Background()).(*PausedError)
+	assert.True(t, paused)
+
+	ctx, cancel := WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	time.Sleep(600 * time.Millisecond)
+
+	var timeoutError *TimeoutError
+	assert.True(t, errors.As(saga.Resume(ctx), &timeoutError))
+	assert.EqualError(t, timeoutError, "timed out after 1.5s in step hang")
+}
+
+func TestSagaPostConditionFailure(t *testing.T) {
+	globalState := 0
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
+		},
+		{
+			Name:  "addTwo",
+			Run:   func(context.Context) (err error) { globalState = globalState + 1; return },
+			Abort: func(context.Context) (err error) { globalState = globalState - 1; return },
+			Post: []Condition{
+				{Name: "state at 3", Check: func(context.Context) error { return fmt.Errorf("state is %d", globalState) }},
+			},
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	var conditionError *ConditionError
+	assert.True(t, errors.As(err, &conditionError))
+	assert.EqualError(t, conditionError, "expected state at 3 after addTwo: state is 2")
+	assert.Equal(t, 0, globalState)
+}
+
+func TestSagaPreConditionFailure(t *testing.T) {
+	ran := false
+	steps := []Step{
+		{
+			Name:  "guarded",
+			Run:   func(context.Context) (err error) { ran = true; return },
+			Abort: func(context.Context) (err error) { t.Fail(); return },
+			Pre: []Condition{
+				{Name: "a clean index", Check: func(context.Context) error { return errors.New("foo is staged") }},
+			},
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	assert.EqualError(t, err, "guarded: expected a clean index before guarded: foo is staged")
+	assert.False(t, ran)
+}
+
+func TestSagaStateReachesUndo(t *testing.T) {
+	const commit Key = "commitSha"
+	undone := ""
+	steps := []Step{
+		{
+			Name: "commit",
+			Run: func(ctx context.Context) (err error) {
+				StateFrom(ctx).Set(commit, "abc123")
+				return
+			},
+			Undo: func(ctx context.Context) (err error) {
+				undone, err = StateFrom(ctx).Get(commit)
+				return
+			},
+		},
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
+		},
+	}
+
+	saga := New(steps)
+	err := saga.Run(context.TODO())
+
+	assert.Error(t, err)
+	assert.Equal(t, "abc123", undone)
+	assert.Equal(t, map[Key]string{commit: "abc123"}, saga.State().Values())
+}
+
+func TestSagaMarksCompensations(t *testing.T) {
+	ran, undone := true, false
+	steps := []Step{
+		{
+			Name: "commit",
+			Run: func(ctx context.Context) error {
+				ran = Compensating(ctx)
+				return nil
+			},
+			Undo: func(ctx context.Context) error {
+				undone = Compensating(ctx)
+				return nil
+			},
+		},
+		{
+			Name: "boom!",
+			Run:  func(context.Context) (err error) { return errors.New("uh oh!") },
+		},
+	}
+
+	saga := New(steps)
+	assert.Error(t, saga.Run(context.TODO()))
+	assert.False(t, ran)
+	assert.True(t, undone)
+}
+
+type outputFailure struct {
+	output string
+}
+
+func (o outputFailure) Error() string {
+	return "exit status 128"
+}
+
+func (o outputFailure) CommandOutput() string {
+	return o.output
+}
+
+func TestSagaUndoAfterSuccess(t *testing.T) {
+	globalState := 0
+	steps := []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { globalState = globalState + 1; return },
+			Undo: func(context.Context) (err error) { globalState = globalState - 1; return },
+		},
+		{
+			Name: "double",
+			Run:  func(context.Context) (err error) { globalState = globalState * 2; return },
+			Undo: func(context.Context) (err error) { globalState = globalState / 2; return },
+		},
+	}
+
+	saga := New(steps)
+	assert.NoError(t, saga.Run(context.TODO()))
+	assert.Equal(t, globalState, 2)
+
+	err := saga.Undo(context.TODO())
+
+	assert.Equal(t, globalState, 0)
+	assert.NoError(t, err)
+}
+
+func pausingSteps(globalState *int, conflicted *bool) []Step {
+	return []Step{
+		{
+			Name: "addOne",
+			Run:  func(context.Context) (err error) { *globalState = *globalState + 1; return },
+			Undo: func(context.Context) (err error) { *globalState = *globalState - 1; return },
+		},
+		{
+			Name: "addTwo",
+			Run: func(ctx context.Context) (err error) {
+				StateFrom(ctx).Set("paused", "yes")
+				*conflicted = true
+				return Pause(errors.New("conflicts"))
+			},
+			Resume: func(context.Context) (err error) {
+				if *conflicted {
+					return Pause(errors.New("conflicts"))
+				}
+				*globalState = *globalState + 2
+				return
+			},
+			Abort: func(context.Context) (err error) { *conflicted = false; return },
+			Undo:  func(context.Context) (err error) { *globalState = *globalState - 2; return },
+		},
+		{
+			Name: "double",
+			Run:  func(context.Context) (err error) { *globalState = *globalState * 2; return },
+		},
+	}
+}
+
+func TestSagaPause(t *testing.T) {
+	globalState := 0
+	conflicted := false
+	events := []EventKind{}
+
+	saga := New(pausingSteps(&globalState, &conflicted))
+	saga.Observe(func(event Event) { events = append(events, event.Kind) })
+	err := saga.Run(context.TODO())
+
+	var paused *PausedError
+	assert.True(t, errors.As(err, &paused))
+	assert.Equal(t, "addTwo", paused.Step)
+	assert.EqualError(t, paused.Err, "conflicts")
+	assert.Equal(t, 1, globalState)
+	assert.Equal(t, []EventKind{StepStarted, StepSucceeded, StepStarted, StepPaused}, events)
+	assert.Equal(t, Checkpoint{Paused: 1, State: map[Key]string{"paused": "yes"}}, saga.Checkpoint())
+}
+
+func TestSagaResumeAfterPause(t *testing.T) {
+	globalState := 0
+	conflicted := false
+
+	saga := New(pausingSteps(&globalState, &conflicted))
+	assert.Error(t, saga.Run(context.TODO()))
+	checkpoint := saga.Checkpoint()
+
+	resumed := New(pausingSteps(&globalState, &conflicted))
+	assert.NoError(t, resumed.Restore(checkpoint))
+
+	var paused *PausedError
+	assert.True(t, errors.As(resumed.Resume(context.TODO()), &paused))
+	assert.Equal(t, 1, globalState)
+
+	conflicted = false
+	assert.NoError(t, resumed.Resume(context.TODO()))
+	assert.Equal(t, 6, globalState)
+	assert.Equal(t, map[Key]string{"paused": "yes"}, resumed.State().Values())
+	assert.Error(t, resumed.Resume(context.TODO()))
+}
+
+func TestSagaAbortAfterPause(t *testing.T) {
+	globalState := 0
+	conflicted := false
+
+	saga := New(pausingSteps(&globalState, &conflicted))
+	assert.Error(t, saga.Abort(context.TODO()))
+	assert.Error(t, saga.Run(context.TODO()))
+
+	resumed := New(pausingSteps(&globalState, &conflicted))
+	assert.NoError(t, resumed.Restore(saga.Checkpoint()))
+	assert.NoError(t, resumed.Abort(context.TODO()))
+
+	assert.Equal(t, 0, globalState)
+	assert.False(t, conflicted)
+	assert.Error(t, resumed.Resume(context.TODO()))
+}
+
+func TestSagaResumeFailureAbortsPausedStep(t *testing.T) {
+	globalState := 0
+	conflicted := false
+	steps := pausingSteps(&globalState, &conflicted)
+	steps[1].Resume = func(context.Context) error { return errors.New("uh oh!") }
+
+	saga := New(steps)
+	assert.Error(t, saga.Run(context.TODO()))
+
+	err := saga.Resume(context.TODO())
+
+	var sagaError *Error
+	assert.True(t, errors.As(err, &sagaError))
+	assert.Equal(t, "addTwo", sagaError.Step)
+	assert.Equal(t, []string{"addTwo", "addOne"}, sagaError.Undone)
+	assert.Equal(t, 0, globalState)
+	assert.False(t, conflicted)
+}
+
+func TestSagaRestoreOutOfRange(t *testing.T) {
+	saga := New(pausingSteps(new(int), new(bool)))
+
+	assert.Error(t, saga.Restore(Checkpoint{Paused: 3}))
+	assert.Error(t, saga.Resume(context.TODO()))
 }
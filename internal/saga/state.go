@@ -0,0 +1,79 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Key names a value that a step records, for later steps and compensations
+// to read.
+type Key string
+
+// State holds what the steps of a saga recorded while running, such as the
+// sha of a commit they made.
+type State struct {
+	mu     sync.Mutex
+	values map[Key]string
+}
+
+func (s *State) Set(key Key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.values == nil {
+		s.values = map[Key]string{}
+	}
+	s.values[key] = value
+}
+
+func (s *State) Get(key Key) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return "", fmt.Errorf("%s was not recorded", key)
+	}
+
+	return value, nil
+}
+
+// Values is a copy of everything recorded so far.
+func (s *State) Values() map[Key]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := map[Key]string{}
+	for key, value := range s.values {
+		values[key] = value
+	}
+
+	return values
+}
+
+type stateKey struct{}
+
+func withState(ctx context.Context, state *State) context.Context {
+	return context.WithValue(ctx, stateKey{}, state)
+}
+
+// StateFrom is the state of the saga that ctx was handed out by. Outside of
+// a saga it is empty.
+func StateFrom(ctx context.Context) *State {
+	if state, ok := ctx.Value(stateKey{}).(*State); ok {
+		return state
+	}
+
+	return &State{}
+}
+
+type compensatingKey struct{}
+
+// Compensating reports whether ctx was handed to an Undo or an Abort, rather
+// than to a step running forward.
+func Compensating(ctx context.Context) bool {
+	compensating, _ := ctx.Value(compensatingKey{}).(bool)
+
+	return compensating
+}
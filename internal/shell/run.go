@@ -1,24 +1,106 @@
 package shell
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ericTsiliacos/portal/internal/logger"
 )
 
-func Run(cmd *exec.Cmd, verbose bool) (err error) {
+type CommandError struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) CommandOutput() string {
+	return e.Output
+}
+
+// waitDelay is how long RunContext keeps waiting for output once its context
+// is done and the command has been killed.
+const waitDelay = time.Second
+
+// RunContext runs cmd, made with exec.CommandContext, and returns what it
+// wrote to stdout and stderr. Killing git doesn't kill the processes it
+// spawned, like ssh, and they keep its output open, so once ctx is done
+// RunContext stops waiting for them.
+func RunContext(ctx context.Context, cmd *exec.Cmd, verbose bool) (stdout string, stderr string, err error) {
 	if verbose {
 		fmt.Println(cmd.String())
 	}
 
 	logger.LogInfo.Println(cmd.String())
 
-	output, err := cmd.CombinedOutput()
+	combined := &lockedBuffer{}
+	stdoutBuffer := &lockedBuffer{}
+	stderrBuffer := &lockedBuffer{}
+	cmd.Stdout = io.MultiWriter(combined, stdoutBuffer)
+	cmd.Stderr = io.MultiWriter(combined, stderrBuffer)
+
+	start := time.Now()
+	if err = cmd.Start(); err == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			select {
+			case err = <-done:
+			case <-time.After(waitDelay):
+				err = ctx.Err()
+			}
+		}
+	}
+
+	stdout, stderr = stdoutBuffer.String(), stderrBuffer.String()
+	traceCommand(cmd, err, stderr, start)
 
+	output := combined.String()
 	if verbose {
-		fmt.Println(string(output))
+		fmt.Println(output)
+	}
+
+	logger.LogInfo.Println(output)
+
+	if err != nil {
+		logger.LogError.Println(err)
+		return stdout, stderr, &CommandError{Command: strings.Join(cmd.Args, " "), Output: output, Err: err}
 	}
 
 	return
 }
+
+type lockedBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
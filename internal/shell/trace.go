@@ -0,0 +1,28 @@
+package shell
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/trace"
+)
+
+func traceCommand(cmd *exec.Cmd, err error, stderr string, start time.Time) {
+	trace.Command(cmd.Args, ExitCode(err), stderr, time.Since(start))
+}
+
+// ExitCode is how a command that returned err exited, -1 when it didn't exit
+// by itself, e.g. it couldn't start or was killed.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return exitError.ExitCode()
+	}
+
+	return -1
+}
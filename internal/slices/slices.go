@@ -7,3 +7,13 @@ func Map(vs []string, f func(string) string) []string {
 	}
 	return vsm
 }
+
+func Filter(vs []string, f func(string) bool) []string {
+	vsf := []string{}
+	for _, v := range vs {
+		if f(v) {
+			vsf = append(vsf, v)
+		}
+	}
+	return vsf
+}
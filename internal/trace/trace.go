@@ -0,0 +1,238 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/saga"
+)
+
+// retained is how many traces are kept, older ones are removed as new runs
+// start.
+const retained = 20
+
+const extension = ".jsonl"
+
+// Entry is one line of a trace.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Type     string            `json:"type"`
+	Run      string            `json:"run,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Step     string            `json:"step,omitempty"`
+	Event    string            `json:"event,omitempty"`
+	Command  []string          `json:"command,omitempty"`
+	ExitCode int               `json:"exitCode"`
+	Stderr   string            `json:"stderr,omitempty"`
+	Duration int64             `json:"durationMs,omitempty"`
+	When     string            `json:"when,omitempty"`
+	Refs     map[string]string `json:"refs,omitempty"`
+	State    map[string]string `json:"state,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Paused   bool              `json:"paused,omitempty"`
+}
+
+const (
+	TypeStart   = "start"
+	TypeStep    = "step"
+	TypeCommand = "command"
+	TypeRefs    = "refs"
+	TypeEnd     = "end"
+)
+
+// Trace records a push or pull as it runs. Only one is active at a time,
+// so that every git command portal runs ends up in it.
+type Trace struct {
+	ID string
+
+	mu   sync.Mutex
+	file *os.File
+	step string
+}
+
+var (
+	activeMu sync.Mutex
+	active   *Trace
+)
+
+// Start begins the trace of a run of command and makes it the active one.
+func Start(dir string, command string, version string) (*Trace, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return nil, err
+	}
+
+	prune(dir, retained-1)
+
+	id := fmt.Sprintf("%s-%s", time.Now().Format("20060102T150405.000"), command)
+	file, err := os.Create(filepath.Join(dir, id+extension))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Trace{ID: id, file: file}
+	t.write(Entry{Type: TypeStart, Run: command, Version: version})
+
+	activeMu.Lock()
+	active = t
+	activeMu.Unlock()
+
+	return t, nil
+}
+
+// Observe records saga events, and which step the commands that follow
+// belong to.
+func (t *Trace) Observe(event saga.Event) {
+	entry := Entry{Type: TypeStep, Step: event.Step, Event: event.Kind.String(), Duration: event.Elapsed.Milliseconds()}
+	if event.Err != nil {
+		entry.Error = event.Err.Error()
+	}
+
+	t.write(entry)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch event.Kind {
+	case saga.StepStarted:
+		t.step = event.Step
+	case saga.UndoStarted:
+		t.step = "undo " + event.Step
+	default:
+		t.step = ""
+	}
+}
+
+func (t *Trace) Refs(when string, refs map[string]string) {
+	t.write(Entry{Type: TypeRefs, When: when, Refs: refs})
+}
+
+// Finish records how the run ended along with what its steps recorded, and
+// stops the trace.
+func (t *Trace) Finish(err error, state map[saga.Key]string) {
+	entry := Entry{Type: TypeEnd, State: map[string]string{}}
+	for key, value := range state {
+		entry.State[string(key)] = value
+	}
+	if err != nil {
+		var paused *saga.PausedError
+		entry.Error = err.Error()
+		entry.Paused = errors.As(err, &paused)
+	}
+
+	t.write(entry)
+
+	activeMu.Lock()
+	if active == t {
+		active = nil
+	}
+	activeMu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_ = t.file.Close()
+}
+
+// Command records a command to the active trace, if there is one.
+func Command(args []string, exitCode int, stderr string, duration time.Duration) {
+	activeMu.Lock()
+	t := active
+	activeMu.Unlock()
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	step := t.step
+	t.mu.Unlock()
+
+	t.write(Entry{Type: TypeCommand, Step: step, Command: args, ExitCode: exitCode, Stderr: stderr, Duration: duration.Milliseconds()})
+}
+
+func (t *Trace) write(entry Entry) {
+	entry.Time = time.Now()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, _ = t.file.Write(append(data, '\n'))
+}
+
+// List is the ids of the traces in dir, oldest first.
+func List(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), extension) {
+			ids = append(ids, strings.TrimSuffix(file.Name(), extension))
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Read loads a trace, "last" being the latest one.
+func Read(dir string, id string) (string, []Entry, error) {
+	if id == "last" {
+		ids, err := List(dir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(ids) == 0 {
+			return "", nil, fmt.Errorf("no traces in %s", dir)
+		}
+
+		id = ids[len(ids)-1]
+	}
+
+	file, err := os.Open(filepath.Join(dir, filepath.Base(id)+extension))
+	if err != nil {
+		return "", nil, fmt.Errorf("no trace %s in %s", id, dir)
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return id, entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return id, entries, scanner.Err()
+}
+
+func prune(dir string, keep int) {
+	ids, err := List(dir)
+	if err != nil {
+		return
+	}
+
+	for len(ids) > keep {
+		_ = os.Remove(filepath.Join(dir, ids[0]+extension))
+		ids = ids[1:]
+	}
+}
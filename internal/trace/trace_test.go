@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ericTsiliacos/portal/internal/saga"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceRecordsARun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "traces")
+	defer os.RemoveAll(dir)
+
+	trace, err := Start(dir, "push", "1.0.0")
+	assert.NoError(t, err)
+
+	trace.Refs("before", map[string]string{"HEAD": "abc"})
+	trace.Observe(saga.Event{Kind: saga.StepStarted, Step: "git push"})
+	Command([]string{"git", "push"}, 1, "rejected", 20*time.Millisecond)
+	trace.Observe(saga.Event{Kind: saga.StepFailed, Step: "git push", Elapsed: 20 * time.Millisecond, Err: errors.New("rejected")})
+	trace.Finish(errors.New("rejected"), map[saga.Key]string{"portalCommitSha": "def"})
+
+	Command([]string{"git", "status"}, 0, "", time.Millisecond)
+
+	id, entries, err := Read(dir, "last")
+	assert.NoError(t, err)
+	assert.Equal(t, trace.ID, id)
+
+	types := []string{}
+	for _, entry := range entries {
+		types = append(types, entry.Type)
+	}
+	assert.Equal(t, []string{TypeStart, TypeRefs, TypeStep, TypeCommand, TypeStep, TypeEnd}, types)
+
+	assert.Equal(t, "git push", entries[3].Step)
+	assert.Equal(t, 1, entries[3].ExitCode)
+	assert.Equal(t, "rejected", entries[3].Stderr)
+	assert.Equal(t, int64(20), entries[3].Duration)
+	assert.Equal(t, "def", entries[5].State["portalCommitSha"])
+	assert.Equal(t, "rejected", entries[5].Error)
+}
+
+func TestTraceKeepsTheLatestRuns(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "traces")
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < retained+2; i++ {
+		_ = ioutil.WriteFile(dir+"/20200101T0000"+string(rune('a'+i))+"-push"+extension, nil, 0644)
+	}
+
+	trace, err := Start(dir, "pull", "")
+	assert.NoError(t, err)
+	trace.Finish(nil, nil)
+
+	ids, _ := List(dir)
+	assert.Len(t, ids, retained)
+	assert.Equal(t, trace.ID, ids[len(ids)-1])
+}
+
+func TestReadWithoutTraces(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "traces")
+	defer os.RemoveAll(dir)
+
+	_, _, err := Read(dir, "last")
+	assert.Error(t, err)
+}